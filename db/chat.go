@@ -1,24 +1,304 @@
 package db
 
 import (
+	"time"
+
 	"github.com/xvepkj/chatapp-backend/models"
+	"github.com/xvepkj/chatapp-backend/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// AddMessage saves a message, tagging the language it is written in. The
+// SourceLanguage set by the caller is kept when the content does not tell.
 func AddMessage(db *gorm.DB, message *models.Message) error {
+	if language := utils.DetectLanguage(message.Content, message.SourceLanguage); language != "" {
+		message.SourceLanguage = language
+	}
+
 	result := db.Create(message)
 	return result.Error
 }
 
-func GetMessagesBetween(db *gorm.DB, senderID string, receiverID string) ([]models.Message, error) {
+// GetMessagesBetween returns the messages exchanged by two users, leaving out
+// the ones viewer deleted for themselves
+func GetMessagesBetween(db *gorm.DB, viewer string, senderID string, receiverID string) ([]models.Message, error) {
+	var messages []models.Message
+
+	result := db.Scopes(visibleTo(viewer), withReplyCounts).
+		Where("((sender_id = ? AND receipient_id = ?) OR (sender_id = ? AND receipient_id = ?))",
+			senderID, receiverID, receiverID, senderID).Find(&messages)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return messages, nil
+}
+
+// GetMessagesSince returns up to limit messages sent to or by the user, or to
+// a conversation the user is a member of, with an ID greater than lastID, oldest first
+func GetMessagesSince(db *gorm.DB, username string, lastID uint, limit int) ([]models.Message, error) {
 	var messages []models.Message
 
-	result := db.Where("(sender_id = ? AND receipient_id = ?) OR (sender_id = ? AND receipient_id = ?)",
-		senderID, receiverID, receiverID, senderID).Find(&messages)
+	result := db.Scopes(visibleTo(username)).Where(`(sender_id = ? OR receipient_id = ?
+		OR conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_name = ?)) AND id > ?`,
+		username, username, username, lastID).
+		Order("id").Limit(limit).Find(&messages)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return messages, nil
+}
+
+// GetConversationPartners returns the usernames the user has exchanged messages
+// with or shares a group conversation with
+func GetConversationPartners(db *gorm.DB, username string) ([]string, error) {
+	var partners []string
+
+	result := db.Raw(`SELECT receipient_id FROM messages WHERE sender_id = ? AND receipient_id <> '' AND deleted_at IS NULL
+		UNION SELECT sender_id FROM messages WHERE receipient_id = ? AND deleted_at IS NULL
+		UNION SELECT other.user_name FROM conversation_members mine
+			JOIN conversation_members other ON other.conversation_id = mine.conversation_id
+			WHERE mine.user_name = ?`,
+		username, username, username).Scan(&partners)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return partners, nil
+}
+
+// MarkMessagesDelivered stamps delivered_at on messages sent to the recipient,
+// directly or to a conversation they are a member of. Messages are picked by
+// ID, or when ids is empty, as every message up to and including upToID from
+// senderID, or in conversationID when it is set. Only the messages that
+// changed are returned.
+func MarkMessagesDelivered(db *gorm.DB, recipient string, ids []uint, senderID string, conversationID uint, upToID uint, at time.Time) ([]models.Message, error) {
+	return markMessages(db, "delivered_at", map[string]interface{}{"delivered_at": at},
+		recipient, ids, senderID, conversationID, upToID, at)
+}
+
+// MarkMessagesRead stamps read_at, and delivered_at if still missing, on messages
+// sent to the recipient, picked like MarkMessagesDelivered
+func MarkMessagesRead(db *gorm.DB, recipient string, ids []uint, senderID string, conversationID uint, upToID uint, at time.Time) ([]models.Message, error) {
+	return markMessages(db, "read_at", map[string]interface{}{
+		"read_at":      at,
+		"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", at),
+	}, recipient, ids, senderID, conversationID, upToID, at)
+}
+
+func markMessages(db *gorm.DB, column string, updates map[string]interface{}, recipient string, ids []uint, senderID string, conversationID uint, upToID uint, at time.Time) ([]models.Message, error) {
+	var messages []models.Message
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		pick := func(query *gorm.DB) *gorm.DB {
+			if len(ids) > 0 {
+				return query.Where("id IN ?", ids)
+			}
+			if conversationID != 0 {
+				return query.Where("conversation_id = ? AND id <= ?", conversationID, upToID)
+			}
+			return query.Where("sender_id = ? AND id <= ?", senderID, upToID)
+		}
+
+		var direct []models.Message
+		if err := pick(tx.Where("receipient_id = ? AND "+column+" IS NULL", recipient)).
+			Order("id").Find(&direct).Error; err != nil {
+			return err
+		}
+		if len(direct) > 0 {
+			changed := make([]uint, len(direct))
+			for i, message := range direct {
+				changed[i] = message.ID
+			}
+			if err := tx.Model(&models.Message{}).Where("id IN ?", changed).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// Messages of conversations get a receipt per member instead
+		var grouped []models.Message
+		if err := pick(tx.Where(`conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_name = ?)
+			AND sender_id <> ?
+			AND id NOT IN (SELECT message_id FROM message_receipts WHERE user_name = ? AND `+column+` IS NOT NULL)`,
+			recipient, recipient, recipient)).
+			Order("id").Find(&grouped).Error; err != nil {
+			return err
+		}
+		if len(grouped) > 0 {
+			receipts := make([]models.MessageReceipt, len(grouped))
+			for i, message := range grouped {
+				receipts[i] = models.MessageReceipt{MessageID: message.ID, UserName: recipient, DeliveredAt: &at}
+				if column == "read_at" {
+					receipts[i].ReadAt = &at
+				}
+			}
+			assignments := map[string]interface{}{
+				"delivered_at": gorm.Expr("COALESCE(message_receipts.delivered_at, excluded.delivered_at)"),
+			}
+			if column == "read_at" {
+				assignments["read_at"] = gorm.Expr("excluded.read_at")
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_name"}},
+				DoUpdates: clause.Assignments(assignments),
+			}).Create(&receipts).Error; err != nil {
+				return err
+			}
+		}
+
+		messages = append(direct, grouped...)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func GetMessageByID(db *gorm.DB, id uint) (*models.Message, error) {
+	var message models.Message
+	result := db.First(&message, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &message, nil
+}
+
+// EditMessage replaces the content of the message, keeping the previous
+// content as a revision
+func EditMessage(db *gorm.DB, message *models.Message, content string, at time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		revision := models.MessageRevision{MessageID: message.ID, Content: message.Content, EditedAt: at}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		// Translations of the old content no longer apply
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageTranslation{}).Error; err != nil {
+			return err
+		}
+
+		language := message.SourceLanguage
+		if detected := utils.DetectLanguage(content, language); detected != "" {
+			language = detected
+		}
+
+		result := tx.Model(message).Updates(map[string]interface{}{"content": content, "edited_at": at, "source_language": language})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		message.Content = content
+		message.SourceLanguage = language
+		message.EditedAt = &at
+		return nil
+	})
+}
 
+// GetMessageRevisions returns the earlier contents of a message, oldest first
+func GetMessageRevisions(db *gorm.DB, messageID uint) ([]models.MessageRevision, error) {
+	var revisions []models.MessageRevision
+	result := db.Where("message_id = ?", messageID).Order("id").Find(&revisions)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	return revisions, nil
+}
+
+// visibleTo leaves out the messages the user deleted for themselves
+func visibleTo(username string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id NOT IN (SELECT message_id FROM hidden_messages WHERE user_name = ?)", username)
+	}
+}
+
+// HideMessage deletes a message for one user only
+func HideMessage(db *gorm.DB, messageID uint, username string) error {
+	hidden := models.HiddenMessage{MessageID: messageID, UserName: username}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&hidden)
+	return result.Error
+}
+
+// IsMessageHidden reports whether the user deleted the message for themselves
+func IsMessageHidden(db *gorm.DB, messageID uint, username string) (bool, error) {
+	var count int64
+	result := db.Model(&models.HiddenMessage{}).Where("message_id = ? AND user_name = ?", messageID, username).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
 
+// TombstoneMessage deletes a message for everyone by wiping its content, edit
+// history, reactions and pin while keeping the message itself as a placeholder
+func TombstoneMessage(db *gorm.DB, message *models.Message, at time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.Reaction{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.PinnedMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageTranslation{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(message).Updates(map[string]interface{}{"content": "", "tombstoned_at": at})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		message.Content = ""
+		message.TombstonedAt = &at
+		return nil
+	})
+}
+
+// withReplyCounts fills Message.ReplyCount
+func withReplyCounts(db *gorm.DB) *gorm.DB {
+	return db.Select(`messages.*, (SELECT COUNT(*) FROM messages replies
+		WHERE replies.reply_to_id = messages.id AND replies.deleted_at IS NULL) AS reply_count`)
+}
+
+// GetMessageWithReplyCount returns a message with its ReplyCount counted
+// like in the message lists
+func GetMessageWithReplyCount(db *gorm.DB, id uint) (*models.Message, error) {
+	var message models.Message
+	result := db.Scopes(withReplyCounts).First(&message, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &message, nil
+}
+
+// GetReplies returns the replies to a message, oldest first, leaving out the
+// ones viewer deleted for themselves
+func GetReplies(db *gorm.DB, viewer string, parentID uint) ([]models.Message, error) {
+	var replies []models.Message
+
+	result := db.Scopes(visibleTo(viewer), withReplyCounts).Where("reply_to_id = ?", parentID).Order("id").Find(&replies)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return replies, nil
+}
+
+func GetMessagesByIDs(db *gorm.DB, ids []uint) ([]models.Message, error) {
+	var messages []models.Message
+	if err := db.Where("id IN ?", ids).Find(&messages).Error; err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xvepkj/chatapp-backend/models"
+)
+
+func TestEditMessageKeepsRevision(t *testing.T) {
+	database := &fakeDatabase{}
+	message := &models.Message{SenderID: "alice", Content: "see you tomorrow", SourceLanguage: "en"}
+	message.ID = 7
+	at := time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC)
+
+	if err := EditMessage(database.open(t), message, "see you on monday", at); err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Content != "see you on monday" || message.EditedAt == nil || !message.EditedAt.Equal(at) {
+		t.Errorf("message = %q edited at %v, want the new content edited at %v", message.Content, message.EditedAt, at)
+	}
+
+	var revision, translations, update *fakeStatement
+	for i, statement := range database.statements {
+		switch {
+		case strings.HasPrefix(statement.query, `INSERT INTO "message_revisions"`):
+			revision = &database.statements[i]
+		case strings.HasPrefix(statement.query, `DELETE FROM "message_translations"`):
+			translations = &database.statements[i]
+		case strings.HasPrefix(statement.query, `UPDATE "messages"`):
+			update = &database.statements[i]
+		}
+	}
+
+	if revision == nil {
+		t.Fatalf("statements = %q, want the previous content saved as a revision", database.queries())
+	}
+	if !hasArg(revision, "see you tomorrow") || !hasArg(revision, at) {
+		t.Errorf("revision saved %v, want the previous content edited at %v", revision.args, at)
+	}
+	if translations == nil {
+		t.Errorf("statements = %q, want the translations of the previous content dropped", database.queries())
+	}
+	if update == nil || !hasArg(update, "see you on monday") {
+		t.Errorf("statements = %q, want the message updated with the new content", database.queries())
+	}
+
+	queries := database.queries()
+	if queries[0] != "BEGIN" || queries[len(queries)-1] != "COMMIT" {
+		t.Errorf("statements = %q, want a single transaction", queries)
+	}
+}
+
+func hasArg(statement *fakeStatement, value interface{}) bool {
+	for _, arg := range statement.args {
+		if want, ok := value.(time.Time); ok {
+			if got, ok := arg.Value.(time.Time); ok && got.Equal(want) {
+				return true
+			}
+			continue
+		}
+		if arg.Value == value {
+			return true
+		}
+	}
+	return false
+}
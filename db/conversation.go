@@ -0,0 +1,184 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateConversation saves the conversation together with its members
+func CreateConversation(db *gorm.DB, conversation *models.Conversation) error {
+	result := db.Create(conversation)
+	return result.Error
+}
+
+func GetConversation(db *gorm.DB, id uint) (*models.Conversation, error) {
+	var conversation models.Conversation
+	result := db.Preload("Members").First(&conversation, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &conversation, nil
+}
+
+// GetConversationsForUser returns the conversations the user is a member of
+func GetConversationsForUser(db *gorm.DB, username string) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+
+	result := db.Preload("Members").
+		Where("id IN (?)", db.Model(&models.ConversationMember{}).Select("conversation_id").Where("user_name = ?", username)).
+		Order("updated_at DESC").Find(&conversations)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return conversations, nil
+}
+
+func RenameConversation(db *gorm.DB, id uint, name string) error {
+	result := db.Model(&models.Conversation{}).Where("id = ?", id).Update("name", name)
+	return result.Error
+}
+
+func DeleteConversation(db *gorm.DB, id uint) error {
+	result := db.Delete(&models.Conversation{}, id)
+	return result.Error
+}
+
+func GetConversationMember(db *gorm.DB, conversationID uint, username string) (*models.ConversationMember, error) {
+	var member models.ConversationMember
+	result := db.Where("conversation_id = ? AND user_name = ?", conversationID, username).First(&member)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &member, nil
+}
+
+// GetConversationMembers returns the members of a conversation, longest standing first
+func GetConversationMembers(db *gorm.DB, conversationID uint) ([]models.ConversationMember, error) {
+	var members []models.ConversationMember
+	result := db.Where("conversation_id = ?", conversationID).Order("joined_at").Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// GetConversationMemberNames returns the usernames of the members of a conversation
+func GetConversationMemberNames(db *gorm.DB, conversationID uint) ([]string, error) {
+	var usernames []string
+	result := db.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).Pluck("user_name", &usernames)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return usernames, nil
+}
+
+func AddConversationMember(db *gorm.DB, member *models.ConversationMember) error {
+	result := db.Create(member)
+	return result.Error
+}
+
+func UpdateConversationMemberRole(db *gorm.DB, conversationID uint, username string, role string) error {
+	result := db.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_name = ?", conversationID, username).Update("role", role)
+	return result.Error
+}
+
+func RemoveConversationMember(db *gorm.DB, conversationID uint, username string) error {
+	result := db.Where("conversation_id = ? AND user_name = ?", conversationID, username).Delete(&models.ConversationMember{})
+	return result.Error
+}
+
+// LeaveConversation removes the user from the conversation, deleting it when
+// they were its last member, and makes the longest standing admin, or member
+// if there is none, owner when no owner is left. It reports whether the
+// conversation was deleted. The conversation is locked meanwhile so members
+// leaving together cannot leave it without an owner.
+func LeaveConversation(db *gorm.DB, conversationID uint, username string) (bool, error) {
+	deleted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var conversation models.Conversation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("conversation_id = ? AND user_name = ?", conversationID, username).Delete(&models.ConversationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		remaining, err := GetConversationMembers(tx, conversationID)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			deleted = true
+			return DeleteConversation(tx, conversationID)
+		}
+
+		successor := remaining[0]
+		for _, member := range remaining {
+			if member.Role == models.RoleOwner {
+				return nil
+			}
+		}
+		for _, member := range remaining {
+			if member.Role == models.RoleAdmin {
+				successor = member
+				break
+			}
+		}
+		return UpdateConversationMemberRole(tx, conversationID, successor.UserName, models.RoleOwner)
+	})
+	return deleted, err
+}
+
+// GetConversationMessages returns the history of a conversation, leaving out
+// the messages viewer deleted for themselves
+func GetConversationMessages(db *gorm.DB, viewer string, conversationID uint) ([]models.Message, error) {
+	var messages []models.Message
+
+	result := db.Scopes(visibleTo(viewer), withReplyCounts).Where("conversation_id = ?", conversationID).Order("id").Find(&messages)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return messages, nil
+}
+
+// SearchPublicChannels returns public channels whose name or description
+// contains query, largest first, with their member counts
+func SearchPublicChannels(db *gorm.DB, query string, limit int, offset int) ([]models.Conversation, error) {
+	var channels []models.Conversation
+
+	tx := db.Model(&models.Conversation{}).
+		Select("conversations.*, (SELECT COUNT(*) FROM conversation_members WHERE conversation_members.conversation_id = conversations.id) AS member_count").
+		Where("kind = ? AND public = ?", models.ConversationChannel, true)
+
+	if query != "" {
+		pattern := "%" + escapeLike(query) + "%"
+		tx = tx.Where(`name ILIKE ? ESCAPE '\' OR description ILIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	result := tx.Order("member_count DESC, id").Limit(limit).Offset(offset).Find(&channels)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return channels, nil
+}
+
+// escapeLike makes the LIKE wildcards in value match themselves, for
+// patterns using ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
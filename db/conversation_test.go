@@ -0,0 +1,22 @@
+package db
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"general", "general"},
+		{"_", `\_`},
+		{"100%", `100\%`},
+		{`a\b`, `a\\b`},
+		{`%_\`, `\%\_\\`},
+	}
+
+	for _, test := range tests {
+		if got := escapeLike(test.value); got != test.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
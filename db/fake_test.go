@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDatabase is a database/sql driver recording the statements gorm sends,
+// answered by exec and query, for testing db functions without Postgres
+type fakeDatabase struct {
+	statements []fakeStatement
+	exec       func(query string, args []driver.NamedValue) (driver.Result, error)
+	query      func(query string, args []driver.NamedValue) (driver.Rows, error)
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.NamedValue
+}
+
+func (d *fakeDatabase) open(t *testing.T) *gorm.DB {
+	t.Helper()
+	dbConn, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(d), WithoutReturning: true}),
+		&gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbConn
+}
+
+// queries returns the statements sent so far, BEGIN, COMMIT and ROLLBACK included
+func (d *fakeDatabase) queries() []string {
+	queries := make([]string, len(d.statements))
+	for i, statement := range d.statements {
+		queries[i] = statement.query
+	}
+	return queries
+}
+
+func (d *fakeDatabase) record(query string, args []driver.NamedValue) {
+	d.statements = append(d.statements, fakeStatement{query: query, args: args})
+}
+
+func (d *fakeDatabase) Connect(context.Context) (driver.Conn, error) { return fakeConn{d}, nil }
+func (d *fakeDatabase) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	database *fakeDatabase
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.database.record("BEGIN", nil)
+	return c, nil
+}
+
+func (c fakeConn) Commit() error {
+	c.database.record("COMMIT", nil)
+	return nil
+}
+
+func (c fakeConn) Rollback() error {
+	c.database.record("ROLLBACK", nil)
+	return nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.database.record(query, args)
+	if c.database.exec == nil {
+		return fakeResult{rows: 1}, nil
+	}
+	return c.database.exec(query, args)
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.database.record(query, args)
+	if c.database.query == nil {
+		return nil, errors.New("unexpected query " + query)
+	}
+	return c.database.query(query, args)
+}
+
+type fakeResult struct {
+	id, rows int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rows, nil }
+
+// countRows is the single row result of a COUNT query
+type countRows struct {
+	count int64
+	done  bool
+}
+
+func (r *countRows) Columns() []string { return []string{"count"} }
+func (r *countRows) Close() error      { return nil }
+
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.count
+	return nil
+}
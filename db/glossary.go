@@ -0,0 +1,88 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+// UserGlossary is the scope of the glossary a user applies to their messages
+func UserGlossary(username string) string {
+	return "user:" + username
+}
+
+// ConversationGlossary is the scope of the glossary of a group conversation
+func ConversationGlossary(conversationID uint) string {
+	return fmt.Sprintf("conversation:%d", conversationID)
+}
+
+func CreateGlossaryEntry(db *gorm.DB, entry *models.GlossaryEntry) error {
+	result := db.Create(entry)
+	return result.Error
+}
+
+// GetGlossary returns the entries of a glossary, alphabetically
+func GetGlossary(db *gorm.DB, scope string) ([]models.GlossaryEntry, error) {
+	var entries []models.GlossaryEntry
+	result := db.Where("scope = ?", scope).Order("LOWER(term)").Order("target_language").Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// GetGlossaryEntry returns an entry of the given glossary only
+func GetGlossaryEntry(db *gorm.DB, scope string, id uint) (*models.GlossaryEntry, error) {
+	var entry models.GlossaryEntry
+	result := db.Where("scope = ? AND id = ?", scope, id).First(&entry)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &entry, nil
+}
+
+// GlossaryTermExists reports whether the glossary already has an entry other
+// than exceptID for the term, in any case, and target language
+func GlossaryTermExists(db *gorm.DB, scope string, term string, targetLanguage string, exceptID uint) (bool, error) {
+	var count int64
+	result := db.Model(&models.GlossaryEntry{}).
+		Where("scope = ? AND LOWER(term) = LOWER(?) AND target_language = ? AND id <> ?", scope, term, targetLanguage, exceptID).
+		Count(&count)
+	return count > 0, result.Error
+}
+
+func UpdateGlossaryEntry(db *gorm.DB, entry *models.GlossaryEntry) error {
+	result := db.Save(entry)
+	return result.Error
+}
+
+// DeleteGlossaryEntry deletes an entry of the given glossary and reports
+// whether there was one
+func DeleteGlossaryEntry(db *gorm.DB, scope string, id uint) (bool, error) {
+	result := db.Where("scope = ? AND id = ?", scope, id).Delete(&models.GlossaryEntry{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetGlossaryTerms returns the entries of the glossaries that apply when
+// translating into targetLanguage. Entries come in the order of scopes, and
+// within a glossary the ones for any language first, so the last entry for a
+// term is the most specific one.
+func GetGlossaryTerms(db *gorm.DB, scopes []string, targetLanguage string) ([]models.GlossaryEntry, error) {
+	var entries []models.GlossaryEntry
+	result := db.Where("scope IN ? AND (target_language = '' OR target_language = ?)", scopes, targetLanguage).
+		Order("target_language").Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	ordered := make([]models.GlossaryEntry, 0, len(entries))
+	for _, scope := range scopes {
+		for _, entry := range entries {
+			if entry.Scope == scope {
+				ordered = append(ordered, entry)
+			}
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddMention records the mention unless the user is already mentioned in the
+// message. It reports whether a mention was added.
+func AddMention(db *gorm.DB, mention *models.Mention) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(mention)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetMentions returns up to limit mentions of the user with an ID below
+// before, or the latest ones when before is zero, newest first. Mentions in
+// messages the user hid or that were deleted for everyone are left out.
+func GetMentions(db *gorm.DB, username string, before uint, limit int) ([]models.Mention, error) {
+	var mentions []models.Mention
+
+	query := db.Preload("Message").
+		Where("mentions.user_name = ?", username).
+		Where("message_id NOT IN (SELECT message_id FROM hidden_messages WHERE user_name = ?)", username).
+		Where("message_id IN (SELECT id FROM messages WHERE tombstoned_at IS NULL AND deleted_at IS NULL)")
+
+	if before > 0 {
+		query = query.Where("mentions.id < ?", before)
+	}
+
+	result := query.Order("mentions.id DESC").Limit(limit).Find(&mentions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return mentions, nil
+}
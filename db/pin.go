@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChatKey identifies the chat a message belongs to: its group conversation,
+// or the pair of users of a direct message in either direction
+func ChatKey(message *models.Message) string {
+	if message.ConversationID != nil {
+		return fmt.Sprintf("conversation:%d", *message.ConversationID)
+	}
+	return DirectChatKey(message.SenderID, message.ReceipientID)
+}
+
+// DirectChatKey identifies the direct chat between two users
+func DirectChatKey(a string, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return "direct:" + a + ":" + b
+}
+
+// ErrTooManyPins is returned by PinMessage when the chat already holds its
+// maximum of pins
+var ErrTooManyPins = errors.New("too many pinned messages")
+
+// PinMessage pins the message unless its chat already holds limit pins, doing
+// nothing if the message already is pinned. It reports whether the message
+// was pinned.
+func PinMessage(db *gorm.DB, pin *models.PinnedMessage, limit int64) (bool, error) {
+	var pinned bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Serialize the pins of one chat so concurrent ones cannot all pass the limit
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", pin.ChatKey).Error; err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&models.PinnedMessage{}).Where("message_id = ?", pin.MessageID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+
+		var count int64
+		if err := tx.Model(&models.PinnedMessage{}).Where("chat_key = ?", pin.ChatKey).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= limit {
+			return ErrTooManyPins
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(pin)
+		pinned = result.RowsAffected > 0
+		return result.Error
+	})
+	return pinned, err
+}
+
+// UnpinMessage reports whether the message was pinned
+func UnpinMessage(db *gorm.DB, messageID uint) (bool, error) {
+	result := db.Where("message_id = ?", messageID).Delete(&models.PinnedMessage{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetPinnedMessages returns the pins of a chat, most recent first
+func GetPinnedMessages(db *gorm.DB, chatKey string) ([]models.PinnedMessage, error) {
+	var pins []models.PinnedMessage
+	result := db.Preload("Message").Where("chat_key = ?", chatKey).Order("pinned_at DESC").Find(&pins)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return pins, nil
+}
+
+// StarMessage bookmarks the message for the user, doing nothing if it already is
+func StarMessage(db *gorm.DB, star *models.StarredMessage) error {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(star)
+	return result.Error
+}
+
+func UnstarMessage(db *gorm.DB, messageID uint, username string) error {
+	result := db.Where("message_id = ? AND user_name = ?", messageID, username).Delete(&models.StarredMessage{})
+	return result.Error
+}
+
+// GetStarredMessages returns the bookmarks of the user, most recent first,
+// leaving out the messages they deleted for themselves
+func GetStarredMessages(db *gorm.DB, username string) ([]models.StarredMessage, error) {
+	var stars []models.StarredMessage
+
+	result := db.Preload("Message").
+		Where("user_name = ?", username).
+		Where("message_id NOT IN (SELECT message_id FROM hidden_messages WHERE user_name = ?)", username).
+		Order("starred_at DESC").Find(&stars)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return stars, nil
+}
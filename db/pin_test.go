@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xvepkj/chatapp-backend/models"
+)
+
+// fakePins answers the statements of PinMessage from pinned, the chat key of
+// every pinned message by ID
+func fakePins(pinned map[uint]string) *fakeDatabase {
+	return &fakeDatabase{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if !strings.HasPrefix(query, `INSERT INTO "pinned_messages"`) {
+				return fakeResult{}, nil
+			}
+			// ("chat_key","pinned_by","pinned_at","message_id")
+			id := uint(args[3].Value.(int64))
+			if _, ok := pinned[id]; ok {
+				return fakeResult{id: int64(id)}, nil
+			}
+			pinned[id] = args[0].Value.(string)
+			return fakeResult{id: int64(id), rows: 1}, nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			var count int64
+			switch {
+			case strings.Contains(query, "message_id = $1"):
+				if _, ok := pinned[uint(args[0].Value.(int64))]; ok {
+					count = 1
+				}
+			case strings.Contains(query, "chat_key = $1"):
+				for _, chatKey := range pinned {
+					if chatKey == args[0].Value.(string) {
+						count++
+					}
+				}
+			default:
+				return nil, errors.New("unexpected query " + query)
+			}
+			return &countRows{count: count}, nil
+		},
+	}
+}
+
+func TestPinMessage(t *testing.T) {
+	const chatKey = "conversation:1"
+	pinnedInChat := func(n int) map[uint]string {
+		pinned := map[uint]string{100: "conversation:2"}
+		for i := 1; i <= n; i++ {
+			pinned[uint(i)] = chatKey
+		}
+		return pinned
+	}
+
+	tests := []struct {
+		name       string
+		pinned     map[uint]string
+		messageID  uint
+		wantPinned bool
+		wantErr    error
+	}{
+		{"below the limit", pinnedInChat(2), 50, true, nil},
+		{"at the limit", pinnedInChat(3), 50, false, ErrTooManyPins},
+		{"already pinned below the limit", pinnedInChat(2), 1, false, nil},
+		{"already pinned at the limit", pinnedInChat(3), 1, false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database := fakePins(test.pinned)
+			before := len(test.pinned)
+
+			pinned, err := PinMessage(database.open(t),
+				&models.PinnedMessage{MessageID: test.messageID, ChatKey: chatKey, PinnedBy: "alice"}, 3)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if pinned != test.wantPinned {
+				t.Errorf("pinned = %v, want %v", pinned, test.wantPinned)
+			}
+			wantAdded := 0
+			if test.wantPinned {
+				wantAdded = 1
+			}
+			if added := len(test.pinned) - before; added != wantAdded {
+				t.Errorf("%d pins added, want %d", added, wantAdded)
+			}
+
+			// The chat is locked first thing in the transaction, before
+			// anything is counted
+			queries := database.queries()
+			if len(queries) < 3 || queries[0] != "BEGIN" || !strings.Contains(queries[1], "pg_advisory_xact_lock") {
+				t.Errorf("statements = %q, want the advisory lock right after BEGIN", queries)
+			}
+			if lock := database.statements[1]; len(lock.args) != 1 || lock.args[0].Value != chatKey {
+				t.Errorf("locked %v, want the chat %s", lock.args, chatKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"time"
+
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddPresenceSession records that the replica holds sessions of the user and
+// reports whether the user was offline on every replica until now, in which
+// case they are marked online
+func AddPresenceSession(db *gorm.DB, replicaID string, username string, at time.Time) (bool, error) {
+	var cameOnline bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Serialize the replicas changing the presence of the same user
+		if err := lockUser(tx, username); err != nil {
+			return err
+		}
+
+		var others int64
+		if err := tx.Model(&models.PresenceSession{}).
+			Where("user_name = ? AND replica_id <> ?", username, replicaID).Count(&others).Error; err != nil {
+			return err
+		}
+
+		session := models.PresenceSession{ReplicaID: replicaID, UserName: username, SeenAt: at}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&session).Error; err != nil {
+			return err
+		}
+
+		if others > 0 {
+			return nil
+		}
+		cameOnline = true
+		return SetPresence(tx, username, true, at)
+	})
+	return cameOnline, err
+}
+
+// RemovePresenceSession records that the replica no longer holds sessions of
+// the user and reports whether no replica does anymore, in which case they
+// are marked offline
+func RemovePresenceSession(db *gorm.DB, replicaID string, username string, at time.Time) (bool, error) {
+	var wentOffline bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, username); err != nil {
+			return err
+		}
+
+		if err := tx.Where("replica_id = ? AND user_name = ?", replicaID, username).
+			Delete(&models.PresenceSession{}).Error; err != nil {
+			return err
+		}
+
+		var remaining int64
+		if err := tx.Model(&models.PresenceSession{}).Where("user_name = ?", username).Count(&remaining).Error; err != nil {
+			return err
+		}
+
+		if remaining > 0 {
+			return nil
+		}
+		wentOffline = true
+		return SetPresence(tx, username, false, at)
+	})
+	return wentOffline, err
+}
+
+// TouchPresenceSessions refreshes the rows of a live replica
+func TouchPresenceSessions(db *gorm.DB, replicaID string, at time.Time) error {
+	result := db.Model(&models.PresenceSession{}).Where("replica_id = ?", replicaID).Update("seen_at", at)
+	return result.Error
+}
+
+// ExpirePresenceSessions deletes the rows not refreshed since before, left by
+// replicas that stopped without cleaning up, marks offline the users no
+// replica holds anymore and returns them
+func ExpirePresenceSessions(db *gorm.DB, before time.Time, at time.Time) ([]string, error) {
+	var stale []string
+	if err := db.Model(&models.PresenceSession{}).Where("seen_at < ?", before).
+		Distinct().Pluck("user_name", &stale).Error; err != nil {
+		return nil, err
+	}
+
+	var offline []string
+	for _, username := range stale {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockUser(tx, username); err != nil {
+				return err
+			}
+
+			if err := tx.Where("user_name = ? AND seen_at < ?", username, before).
+				Delete(&models.PresenceSession{}).Error; err != nil {
+				return err
+			}
+
+			var remaining int64
+			if err := tx.Model(&models.PresenceSession{}).Where("user_name = ?", username).Count(&remaining).Error; err != nil {
+				return err
+			}
+
+			if remaining > 0 {
+				return nil
+			}
+			offline = append(offline, username)
+			return SetPresence(tx, username, false, at)
+		})
+		if err != nil {
+			return offline, err
+		}
+	}
+	return offline, nil
+}
+
+func lockUser(tx *gorm.DB, username string) error {
+	var user models.User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("user_name").
+		Where("user_name = ?", username).First(&user).Error
+}
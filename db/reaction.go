@@ -0,0 +1,38 @@
+package db
+
+import (
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddReaction records the reaction, doing nothing if the user already reacted
+// with the same emoji. It reports whether a reaction was added.
+func AddReaction(db *gorm.DB, reaction *models.Reaction) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RemoveReaction deletes the reaction and reports whether there was one
+func RemoveReaction(db *gorm.DB, messageID uint, username string, emoji string) (bool, error) {
+	result := db.Where("message_id = ? AND user_name = ? AND emoji = ?", messageID, username, emoji).Delete(&models.Reaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetReactionSummaries aggregates the reactions to the messages per emoji, in
+// the order the emojis were first used, flagging the ones viewer used
+func GetReactionSummaries(db *gorm.DB, viewer string, messageIDs []uint) ([]models.ReactionSummary, error) {
+	var summaries []models.ReactionSummary
+
+	result := db.Model(&models.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_name = ?) AS reacted_by_me", viewer).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").Order("MIN(created_at)").
+		Scan(&summaries)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return summaries, nil
+}
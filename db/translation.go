@@ -0,0 +1,61 @@
+package db
+
+import (
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetMessageTranslations returns the stored translations of the messages into language
+func GetMessageTranslations(db *gorm.DB, messageIDs []uint, language string) ([]models.MessageTranslation, error) {
+	var translations []models.MessageTranslation
+	if len(messageIDs) == 0 {
+		return translations, nil
+	}
+
+	result := db.Where("message_id IN ? AND language = ?", messageIDs, language).Find(&translations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return translations, nil
+}
+
+// SaveMessageTranslation stores a translation, replacing the existing one if
+// the message was already translated into that language
+func SaveMessageTranslation(db *gorm.DB, translation *models.MessageTranslation) error {
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "language"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content"}),
+	}).Create(translation)
+	return result.Error
+}
+
+// GetCachedTranslation looks a translation up in the persistent translation cache
+func GetCachedTranslation(db *gorm.DB, key string) (*models.CachedTranslation, error) {
+	var translation models.CachedTranslation
+	result := db.Where("key = ?", key).First(&translation)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &translation, nil
+}
+
+func SaveCachedTranslation(db *gorm.DB, translation *models.CachedTranslation) error {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(translation)
+	return result.Error
+}
+
+// PurgeCachedTranslations deletes the cached translations from source to
+// target, any language matching when empty, and returns how many there were
+func PurgeCachedTranslations(db *gorm.DB, source string, target string) (int64, error) {
+	query := db.Where("1 = 1")
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if target != "" {
+		query = query.Where("target = ?", target)
+	}
+
+	result := query.Delete(&models.CachedTranslation{})
+	return result.RowsAffected, result.Error
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"time"
+
 	"github.com/xvepkj/chatapp-backend/models"
 	"gorm.io/gorm"
 )
@@ -36,3 +38,18 @@ func DeleteUser(db *gorm.DB, username string) error {
 	result := db.Where("user_name = ?", username).Delete(&models.User{})
 	return result.Error
 }
+
+func GetUsersByUsernames(db *gorm.DB, usernames []string) ([]models.User, error) {
+	var users []models.User
+	if err := db.Where("user_name IN ?", usernames).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetPresence records whether the user is connected, stamping the last seen time
+func SetPresence(db *gorm.DB, username string, online bool, at time.Time) error {
+	result := db.Model(&models.User{}).Where("user_name = ?", username).
+		Updates(map[string]interface{}{"online": online, "last_seen": at})
+	return result.Error
+}
@@ -1 +1,102 @@
 package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocol browsers offer alongside the token in Sec-WebSocket-Protocol,
+// since they cannot set an Authorization header on the handshake
+const wsAuthProtocol = "access_token"
+
+// How long a ticket for the WebSocket handshake stays valid
+const wsTicketTTL = 30 * time.Second
+
+var errMissingToken = errors.New("missing authorization token")
+
+// parseJWTToken validates the token and returns its claims
+func parseJWTToken(tokenString string) (jwt.MapClaims, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte("signing-key"), nil // Replace "signing-key" with your actual secret key
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+
+	if _, ok := claims["authenticated_user"].(string); !ok {
+		return nil, errors.New("invalid username in token")
+	}
+
+	return claims, nil
+}
+
+// authenticateWebSocket returns the user a WebSocket handshake is made on
+// behalf of. The JWT is taken from the Authorization header, from the
+// Sec-WebSocket-Protocol header next to wsAuthProtocol, or from a ticket
+// query parameter issued by IssueWebSocketTicket.
+func authenticateWebSocket(r *http.Request) (string, error) {
+	if tokenString := r.Header.Get("Authorization"); tokenString != "" {
+		claims, err := parseJWTToken(tokenString)
+		if err != nil {
+			return "", err
+		}
+		return claims["authenticated_user"].(string), nil
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == wsAuthProtocol && i+1 < len(protocols) {
+			claims, err := parseJWTToken(protocols[i+1])
+			if err != nil {
+				return "", err
+			}
+			return claims["authenticated_user"].(string), nil
+		}
+	}
+
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		claims, err := parseJWTToken(ticket)
+		if err != nil {
+			return "", err
+		}
+		if isTicket, _ := claims["ws_ticket"].(bool); !isTicket {
+			return "", errors.New("invalid websocket ticket")
+		}
+		return claims["authenticated_user"].(string), nil
+	}
+
+	return "", errMissingToken
+}
+
+// IssueWebSocketTicket returns a short lived token the authenticated user can
+// pass as the ticket query parameter when opening /ws
+func IssueWebSocketTicket(c *gin.Context) {
+	username := c.GetString("authenticated_user")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"authenticated_user": username,
+		"ws_ticket":          true,
+		"exp":                time.Now().Add(wsTicketTTL).Unix(),
+	})
+
+	ticket, err := token.SignedString([]byte("signing-key"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(wsTicketTTL.Seconds())})
+}
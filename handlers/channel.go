@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+
+	defaultChannelPage = 20
+	maxChannelPage     = 100
+)
+
+type channelRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+// CreateChannel creates a channel owned by the authenticated user. Public
+// channels show up in SearchChannels, private ones are joined through invites.
+func CreateChannel(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
+	var request channelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel := models.Conversation{
+		Name:        request.Name,
+		Description: request.Description,
+		Kind:        models.ConversationChannel,
+		Public:      request.Public,
+		CreatedBy:   username,
+		Members:     []models.ConversationMember{{UserName: username, Role: models.RoleOwner}},
+	}
+
+	if err := db.CreateConversation(dbConn, &channel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationUpdated(dbConn, h, channel.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "channel created successfully", "channel": channel})
+}
+
+// SearchChannels lists public channels matching the q query parameter, with
+// limit and offset for paging
+func SearchChannels(c *gin.Context, dbConn *gorm.DB) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChannelPage)))
+	if err != nil || limit <= 0 {
+		limit = defaultChannelPage
+	}
+	if limit > maxChannelPage {
+		limit = maxChannelPage
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	channels, err := db.SearchPublicChannels(dbConn, c.Query("q"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"channels": channels})
+}
+
+// JoinChannel adds the authenticated user to a public channel
+func JoinChannel(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+
+	channel, err := db.GetConversation(dbConn, id)
+	if err != nil || channel.Kind != models.ConversationChannel || !channel.Public {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+
+	joinConversation(c, dbConn, h, id)
+}
+
+// CreateInvite returns a signed invite link to a conversation, admins only.
+// The expires_in query parameter sets its lifetime in hours.
+func CreateInvite(c *gin.Context, dbConn *gorm.DB) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return
+	}
+	if !isConversationAdmin(member) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only admins can create invites"})
+		return
+	}
+
+	ttl := defaultInviteTTL
+	if value := c.Query("expires_in"); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in"})
+			return
+		}
+		ttl = time.Duration(hours) * time.Hour
+		if ttl > maxInviteTTL {
+			ttl = maxInviteTTL
+		}
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token, err := generateInviteToken(id, member.UserName, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"invite": token, "path": "/invites/" + token + "/join", "expires_at": expiresAt})
+}
+
+// JoinByInvite adds the authenticated user to the conversation of an invite,
+// as long as the admin who created it still is one
+func JoinByInvite(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, inviter, err := parseInviteToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := db.GetConversation(dbConn, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return
+	}
+
+	member, err := db.GetConversationMember(dbConn, id, inviter)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil || !isConversationAdmin(member) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invite was revoked"})
+		return
+	}
+
+	joinConversation(c, dbConn, h, id)
+}
+
+// joinConversation makes the authenticated user a member of the conversation,
+// doing nothing if they already are
+func joinConversation(c *gin.Context, dbConn *gorm.DB, h *hub.Hub, id uint) {
+	username := c.GetString("authenticated_user")
+
+	if _, err := db.GetConversationMember(dbConn, id, username); err == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "already a member"})
+		return
+	}
+
+	member := models.ConversationMember{ConversationID: id, UserName: username, Role: models.RoleMember}
+	if err := db.AddConversationMember(dbConn, &member); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationUpdated(dbConn, h, id)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "joined successfully", "member": member})
+}
+
+func generateInviteToken(conversationID uint, inviter string, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"invite_conversation": conversationID,
+		"invited_by":          inviter,
+		"exp":                 expiresAt.Unix(),
+	})
+
+	return token.SignedString([]byte("signing-key"))
+}
+
+// parseInviteToken checks the signature and expiry of an invite and returns
+// its conversation and the admin who created it
+func parseInviteToken(tokenString string) (uint, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte("signing-key"), nil // Replace "signing-key" with your actual secret key
+	})
+	if err != nil || !token.Valid {
+		return 0, "", errors.New("invalid or expired invite")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", errors.New("invalid invite claims")
+	}
+
+	id, ok := claims["invite_conversation"].(float64)
+	if !ok || id <= 0 {
+		return 0, "", errors.New("invalid invite")
+	}
+	inviter, ok := claims["invited_by"].(string)
+	if !ok || inviter == "" {
+		return 0, "", errors.New("invalid invite")
+	}
+
+	return uint(id), inviter, nil
+}
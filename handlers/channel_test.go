@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInviteToken(t *testing.T) {
+	token, err := generateInviteToken(42, "alice", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, inviter, err := parseInviteToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 || inviter != "alice" {
+		t.Errorf("got conversation %d invited by %q, want 42 invited by alice", id, inviter)
+	}
+
+	expired, err := generateInviteToken(42, "alice", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseInviteToken(expired); err == nil {
+		t.Error("want an error for an expired invite")
+	}
+
+	anonymous, err := generateInviteToken(42, "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseInviteToken(anonymous); err == nil {
+		t.Error("want an error for an invite without its inviter")
+	}
+}
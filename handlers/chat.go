@@ -1,51 +1,254 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"github.com/xuri/excelize/v2"
 	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
 	"github.com/xvepkj/chatapp-backend/models"
 	"gorm.io/gorm"
 )
 
-func AddMessage(c *gin.Context, dbConn *gorm.DB) {
+// AddMessage saves a message from the authenticated user and delivers it
+// through the hub, the sending path for clients without a WebSocket
+func AddMessage(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
 	var message models.Message
 	if err := c.ShouldBindJSON(&message); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := db.AddMessage(dbConn, &message); err != nil {
+	if message.SenderID != "" && message.SenderID != username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "sender does not match authenticated user"})
+		return
+	}
+	message.SenderID = username
+
+	if err := prepareMessage(dbConn, &message); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errMissingRecipient) || errors.Is(err, errInvalidReply) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, errNotMember) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	mentions, err := saveMessage(dbConn, &message)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	deliverMessage(dbConn, h, nil, message, mentions)
+
 	c.JSON(http.StatusCreated, gin.H{"message": "message added successfully", "user": message})
 }
 
-func AddMessageWebSocket(dbConn *gorm.DB, message *models.Message) error {
+// How long after sending a message its sender may still edit it
+var MessageEditWindow = 15 * time.Minute
+
+type editRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+var (
+	errNotSender        = errors.New("only the sender can edit a message")
+	errMessageDeleted   = errors.New("message was deleted")
+	errEditWindowClosed = errors.New("message can no longer be edited")
+)
+
+// checkEdit returns why the user may not edit the message at now, nil if they may
+func checkEdit(message *models.Message, username string, now time.Time) error {
+	if message.SenderID != username {
+		return errNotSender
+	}
+	if message.TombstonedAt != nil {
+		return errMessageDeleted
+	}
+	if now.Sub(message.CreatedAt) > MessageEditWindow {
+		return errEditWindowClosed
+	}
+	return nil
+}
+
+// Number of characters of the parent message quoted in replies
+const quoteSnippetLength = 120
 
-	if err := db.AddMessage(dbConn, message); err != nil {
+var (
+	errMissingRecipient = errors.New("missing recipient")
+	errNotMember        = errors.New("not a member of the conversation")
+	errInvalidReply     = errors.New("reply_to must be a message of the same chat")
+)
+
+// prepareMessage checks the addressing of a new message from its sender and
+// resets the fields only the server may set
+func prepareMessage(dbConn *gorm.DB, message *models.Message) error {
+	message.Model = gorm.Model{}
+	message.Timestamp = time.Time{}
+	message.DeliveredAt = nil
+	message.ReadAt = nil
+	message.EditedAt = nil
+	message.TombstonedAt = nil
+	message.ReplyCount = 0
+	message.Quote = nil
+
+	message.SourceLanguage = ""
+	message.TranslatedTo = ""
+	message.OriginalContent = ""
+
+	sender, err := db.GetUserByUsername(dbConn, message.SenderID)
+	if err != nil {
 		return err
 	}
+	// Assumed until db.AddMessage detects the language of the content
+	message.SourceLanguage = sender.Language
 
+	if message.ConversationID != nil {
+		if _, err := db.GetConversationMember(dbConn, *message.ConversationID, message.SenderID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errNotMember
+			}
+			return err
+		}
+		message.ReceipientID = ""
+	} else if message.ReceipientID == "" {
+		return errMissingRecipient
+	}
+
+	if message.ReplyToID != nil {
+		return checkReplyParent(dbConn, message)
+	}
 	return nil
 }
 
+// checkReplyParent makes sure a reply answers a message of the same chat
+func checkReplyParent(dbConn *gorm.DB, message *models.Message) error {
+	parent, err := db.GetMessageByID(dbConn, *message.ReplyToID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+
+	// A message the sender deleted for themselves cannot be answered either
+	hidden, err := db.IsMessageHidden(dbConn, parent.ID, message.SenderID)
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return errInvalidReply
+	}
+
+	if message.ConversationID != nil {
+		if parent.ConversationID == nil || *parent.ConversationID != *message.ConversationID {
+			return errInvalidReply
+		}
+		return nil
+	}
+
+	samePair := (parent.SenderID == message.SenderID && parent.ReceipientID == message.ReceipientID) ||
+		(parent.SenderID == message.ReceipientID && parent.ReceipientID == message.SenderID)
+	if parent.ConversationID != nil || !samePair {
+		return errInvalidReply
+	}
+	return nil
+}
+
+// attachQuotes fills the quote of every reply with an excerpt of its parent
+func attachQuotes(dbConn *gorm.DB, messages []models.Message) error {
+	var parentIDs []uint
+	for _, message := range messages {
+		if message.ReplyToID != nil {
+			parentIDs = append(parentIDs, *message.ReplyToID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	parents, err := db.GetMessagesByIDs(dbConn, parentIDs)
+	if err != nil {
+		return err
+	}
+
+	quotes := make(map[uint]*models.MessageQuote, len(parents))
+	for _, parent := range parents {
+		quote := &models.MessageQuote{ID: parent.ID, SenderID: parent.SenderID, Deleted: parent.TombstonedAt != nil}
+		if !quote.Deleted {
+			quote.Snippet = snippet(parent.Content, quoteSnippetLength)
+		}
+		quotes[parent.ID] = quote
+	}
+
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			messages[i].Quote = quotes[*messages[i].ReplyToID]
+		}
+	}
+	return nil
+}
+
+func snippet(content string, length int) string {
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content
+	}
+	return string(runes[:length]) + "…"
+}
+
+// saveMessage saves a new message together with its mentions and returns the
+// mentions to alert
+func saveMessage(dbConn *gorm.DB, message *models.Message) ([]models.Mention, error) {
+	var mentions []models.Mention
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := db.AddMessage(tx, message); err != nil {
+			return err
+		}
+
+		var err error
+		mentions, err = saveMentions(tx, *message)
+		return err
+	})
+	return mentions, err
+}
+
+// GetMessagesBetween returns the messages exchanged by two users, translated
+// into the language of the user asking. With original=true the original text
+// of translated messages is included.
 func GetMessagesBetween(c *gin.Context, dbConn *gorm.DB) {
 	senderID := c.Param("senderID")
 	receiverId := c.Param("receiverID")
 
-	messages, err := db.GetMessagesBetween(dbConn, senderID, receiverId)
+	username := c.GetString("authenticated_user")
+
+	messages, err := db.GetMessagesBetween(dbConn, username, senderID, receiverId)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "messages not found"})
 		return
 	}
 
+	if err := attachReactions(dbConn, username, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := translateMessages(dbConn, username, messages, c.Query("original") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"messages": messages})
 }
 
@@ -54,7 +257,7 @@ func ExportMessagesToExcel(c *gin.Context, dbConn *gorm.DB) {
 	senderID := c.Param("senderID")
 	receiverId := c.Param("receiverID")
 
-	messages, err := db.GetMessagesBetween(dbConn, senderID, receiverId)
+	messages, err := db.GetMessagesBetween(dbConn, c.GetString("authenticated_user"), senderID, receiverId)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "messages not found"})
@@ -101,9 +304,268 @@ func messagesToExcel(messages []models.Message) (*excelize.File, error) {
 		file.SetCellValue(sheet, "A"+strconv.Itoa(row), msg.ID)
 		file.SetCellValue(sheet, "B"+strconv.Itoa(row), msg.SenderID)
 		file.SetCellValue(sheet, "C"+strconv.Itoa(row), msg.ReceipientID)
-		file.SetCellValue(sheet, "D"+strconv.Itoa(row), msg.Content)
+		content := msg.Content
+		if msg.TombstonedAt != nil {
+			content = "This message was deleted"
+		}
+		file.SetCellValue(sheet, "D"+strconv.Itoa(row), content)
 		file.SetCellValue(sheet, "E"+strconv.Itoa(row), msg.Timestamp.String())
 	}
 
 	return file, nil
 }
+
+// EditMessage replaces the content of a message. Only its sender may edit it,
+// within MessageEditWindow of sending it.
+func EditMessage(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	var request editRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := db.GetMessageByID(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	now := time.Now()
+	if err := checkEdit(message, username, now); err != nil {
+		status := http.StatusForbidden
+		if errors.Is(err, errMessageDeleted) {
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Content == message.Content {
+		c.JSON(http.StatusOK, gin.H{"message": "message unchanged", "data": message})
+		return
+	}
+
+	var mentions []models.Mention
+	err = dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := db.EditMessage(tx, message, request.Content, now); err != nil {
+			return err
+		}
+
+		var err error
+		mentions, err = saveMentions(tx, *message)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deliverEdit(dbConn, h, *message, mentions)
+
+	c.JSON(http.StatusOK, gin.H{"message": "message edited successfully", "data": message})
+}
+
+// notifyMessageEdited pushes the new content of a message to everyone who
+// sees it, each in their own language
+func notifyMessageEdited(dbConn *gorm.DB, h *hub.Hub, message models.Message) {
+	audience, err := messageAudience(dbConn, message)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get message audience")
+		return
+	}
+
+	frames, err := newTranslatedFrames(dbConn, hub.TypeMessageEdited, message, audience)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode message frame")
+		return
+	}
+
+	for _, username := range audience {
+		if username == message.SenderID {
+			h.Send(username, frames.original)
+		} else {
+			h.Send(username, frames.forReader(username))
+		}
+	}
+}
+
+// DeleteMessage deletes a message. With mode=me, the default, it is only hidden
+// from the authenticated user. With mode=everyone its sender wipes it for all
+// participants, leaving a placeholder behind.
+func DeleteMessage(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	mode := c.DefaultQuery("mode", hub.DeleteForMe)
+	if mode != hub.DeleteForMe && mode != hub.DeleteForEveryone {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mode"})
+		return
+	}
+
+	message, err := db.GetMessageByID(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	visible, err := canSeeMessage(dbConn, username, message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	payload := hub.MessageDeletedPayload{MessageID: message.ID, Mode: mode, ConversationID: message.ConversationID}
+
+	if mode == hub.DeleteForMe {
+		if err := db.HideMessage(dbConn, message.ID, username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only the user's own devices need to drop the message
+		if frame, err := hub.NewFrame(hub.TypeMessageDeleted, "", payload); err == nil {
+			h.Send(username, frame)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "message deleted for you"})
+		return
+	}
+
+	if message.SenderID != username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the sender can delete a message for everyone"})
+		return
+	}
+
+	if message.TombstonedAt == nil {
+		if err := db.TombstoneMessage(dbConn, message, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	notifyMessageAudience(dbConn, h, *message, hub.TypeMessageDeleted, payload)
+
+	c.JSON(http.StatusOK, gin.H{"message": "message deleted for everyone"})
+}
+
+// GetMessageRevisions returns the edit history of a message to the users who can see it
+func GetMessageRevisions(c *gin.Context, dbConn *gorm.DB) {
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	message, err := db.GetMessageByID(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	visible, err := canSeeMessage(dbConn, c.GetString("authenticated_user"), message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	revisions, err := db.GetMessageRevisions(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"current": message, "revisions": revisions})
+}
+
+func messageID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// canSeeMessage reports whether the user took part in the message, either
+// directly or as a member of its conversation, and did not delete it for
+// themselves
+func canSeeMessage(dbConn *gorm.DB, username string, message *models.Message) (bool, error) {
+	if message.ConversationID == nil {
+		if message.SenderID != username && message.ReceipientID != username {
+			return false, nil
+		}
+	} else {
+		_, err := db.GetConversationMember(dbConn, *message.ConversationID, username)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	hidden, err := db.IsMessageHidden(dbConn, message.ID, username)
+	if err != nil {
+		return false, err
+	}
+	return !hidden, nil
+}
+
+// GetThread returns a message and its replies to the users who can see it
+func GetThread(c *gin.Context, dbConn *gorm.DB) {
+	username := c.GetString("authenticated_user")
+
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	parent, err := db.GetMessageWithReplyCount(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	visible, err := canSeeMessage(dbConn, username, parent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	replies, err := db.GetReplies(dbConn, username, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread := append([]models.Message{*parent}, replies...)
+	if err := attachReactions(dbConn, username, thread); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	parent, replies = &thread[0], thread[1:]
+
+	c.JSON(http.StatusOK, gin.H{"parent": parent, "replies": replies})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xvepkj/chatapp-backend/models"
+)
+
+func TestCheckEdit(t *testing.T) {
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deleted := sent.Add(time.Minute)
+
+	message := func(tombstonedAt *time.Time) *models.Message {
+		message := &models.Message{SenderID: "alice", Content: "hello", TombstonedAt: tombstonedAt}
+		message.CreatedAt = sent
+		return message
+	}
+
+	tests := []struct {
+		name     string
+		message  *models.Message
+		username string
+		now      time.Time
+		want     error
+	}{
+		{"just sent", message(nil), "alice", sent, nil},
+		{"inside the window", message(nil), "alice", sent.Add(MessageEditWindow - time.Second), nil},
+		{"at the end of the window", message(nil), "alice", sent.Add(MessageEditWindow), nil},
+		{"after the window", message(nil), "alice", sent.Add(MessageEditWindow + time.Second), errEditWindowClosed},
+		{"by someone else", message(nil), "bob", sent, errNotSender},
+		{"deleted for everyone", message(&deleted), "alice", sent.Add(2 * time.Minute), errMessageDeleted},
+		{"deleted, after the window", message(&deleted), "alice", sent.Add(MessageEditWindow + time.Hour), errMessageDeleted},
+	}
+
+	for _, test := range tests {
+		if got := checkEdit(test.message, test.username, test.now); !errors.Is(got, test.want) {
+			t.Errorf("%s: checkEdit = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+type conversationRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type memberRequest struct {
+	UserName string `json:"username" binding:"required"`
+	Role     string `json:"role"`
+}
+
+// CreateConversation creates a group conversation owned by the authenticated user
+func CreateConversation(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
+	var request conversationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation := models.Conversation{
+		Name:      request.Name,
+		CreatedBy: username,
+		Members:   []models.ConversationMember{{UserName: username, Role: models.RoleOwner}},
+	}
+
+	seen := map[string]bool{username: true}
+	var others []string
+	for _, member := range request.Members {
+		if !seen[member] {
+			seen[member] = true
+			others = append(others, member)
+		}
+	}
+
+	if len(others) > 0 {
+		users, err := db.GetUsersByUsernames(dbConn, others)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(users) != len(others) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown member"})
+			return
+		}
+		for _, member := range others {
+			conversation.Members = append(conversation.Members, models.ConversationMember{UserName: member, Role: models.RoleMember})
+		}
+	}
+
+	if err := db.CreateConversation(dbConn, &conversation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationUpdated(dbConn, h, conversation.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "conversation created successfully", "conversation": conversation})
+}
+
+// GetConversations lists the conversations of the authenticated user
+func GetConversations(c *gin.Context, dbConn *gorm.DB) {
+	username := c.GetString("authenticated_user")
+
+	conversations, err := db.GetConversationsForUser(dbConn, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+}
+
+func GetConversation(c *gin.Context, dbConn *gorm.DB) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+	if _, ok := requireMember(c, dbConn, id); !ok {
+		return
+	}
+
+	conversation, err := db.GetConversation(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// RenameConversation changes the name of a conversation, admins only
+func RenameConversation(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+
+	var request conversationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return
+	}
+	if !isConversationAdmin(member) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only admins can rename the conversation"})
+		return
+	}
+
+	if err := db.RenameConversation(dbConn, id, request.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationUpdated(dbConn, h, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "conversation renamed successfully"})
+}
+
+// AddConversationMember adds a user to a conversation, admins only. Only the
+// owner can add admins.
+func AddConversationMember(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+
+	var request memberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Role == "" {
+		request.Role = models.RoleMember
+	}
+	if request.Role != models.RoleMember && request.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return
+	}
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return
+	}
+	if !isConversationAdmin(member) || (request.Role == models.RoleAdmin && member.Role != models.RoleOwner) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to add members with this role"})
+		return
+	}
+
+	if _, err := db.GetUserByUsername(dbConn, request.UserName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if _, err := db.GetConversationMember(dbConn, id, request.UserName); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is already a member"})
+		return
+	}
+
+	newMember := models.ConversationMember{ConversationID: id, UserName: request.UserName, Role: request.Role}
+	if err := db.AddConversationMember(dbConn, &newMember); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationUpdated(dbConn, h, id)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "member added successfully", "member": newMember})
+}
+
+// RemoveConversationMember removes a user from a conversation, admins only.
+// The owner cannot be removed and only the owner can remove admins.
+func RemoveConversationMember(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+	username := c.Param("username")
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return
+	}
+	if member.UserName == username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "use leave to remove yourself"})
+		return
+	}
+
+	target, err := db.GetConversationMember(dbConn, id, username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+
+	allowed := member.Role == models.RoleOwner || (member.Role == models.RoleAdmin && target.Role == models.RoleMember)
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to remove this member"})
+		return
+	}
+
+	if err := db.RemoveConversationMember(dbConn, id, username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationRemoved(h, id, username)
+	notifyConversationUpdated(dbConn, h, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "member removed successfully"})
+}
+
+// LeaveConversation removes the authenticated user from a conversation. When
+// the owner leaves, the longest standing admin, or member if there is none,
+// takes over. The conversation is deleted once nobody is left.
+func LeaveConversation(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return
+	}
+
+	deleted, err := db.LeaveConversation(dbConn, id, member.UserName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not a member of the conversation"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyConversationRemoved(h, id, member.UserName)
+	if !deleted {
+		notifyConversationUpdated(dbConn, h, id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "left conversation successfully"})
+}
+
+// GetConversationMessages returns the history of a conversation to its members,
+// translated like GetMessagesBetween
+func GetConversationMessages(c *gin.Context, dbConn *gorm.DB) {
+	id, ok := conversationID(c)
+	if !ok {
+		return
+	}
+	if _, ok := requireMember(c, dbConn, id); !ok {
+		return
+	}
+
+	username := c.GetString("authenticated_user")
+
+	messages, err := db.GetConversationMessages(dbConn, username, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "messages not found"})
+		return
+	}
+
+	if err := attachReactions(dbConn, username, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := translateMessages(dbConn, username, messages, c.Query("original") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+func conversationID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// requireMember returns the authenticated user's membership of the conversation.
+// Non members get a not found so conversations do not leak.
+func requireMember(c *gin.Context, dbConn *gorm.DB, id uint) (*models.ConversationMember, bool) {
+	member, err := db.GetConversationMember(dbConn, id, c.GetString("authenticated_user"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return member, true
+}
+
+func isConversationAdmin(member *models.ConversationMember) bool {
+	return member.Role == models.RoleOwner || member.Role == models.RoleAdmin
+}
+
+// notifyConversationUpdated pushes the current state of a conversation to its members
+func notifyConversationUpdated(dbConn *gorm.DB, h *hub.Hub, id uint) {
+	conversation, err := db.GetConversation(dbConn, id)
+	if err != nil {
+		log.Error().Err(err).Uint("conversation", id).Msg("Failed to load conversation")
+		return
+	}
+
+	frame, err := hub.NewFrame(hub.TypeConversationUpdated, "", conversation)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode conversation frame")
+		return
+	}
+
+	for _, member := range conversation.Members {
+		h.Send(member.UserName, frame)
+	}
+}
+
+func notifyConversationRemoved(h *hub.Hub, id uint, username string) {
+	frame, err := hub.NewFrame(hub.TypeConversationRemoved, "", hub.ConversationRemovedPayload{ConversationID: id})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode conversation frame")
+		return
+	}
+	h.Send(username, frame)
+}
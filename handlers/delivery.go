@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+// deliveryQueue runs jobs in the background, one at a time per key in the
+// order they were queued, and each key on its own goroutine
+type deliveryQueue struct {
+	mu      sync.Mutex
+	pending map[string][]func()
+}
+
+// deliveries fans out messages away from the WebSocket read loops and the
+// REST requests, since translating them may wait on the provider. Jobs are
+// keyed by sender so a sender's messages and edits arrive in order.
+var deliveries = &deliveryQueue{pending: make(map[string][]func())}
+
+func (q *deliveryQueue) enqueue(key string, job func()) {
+	q.mu.Lock()
+	jobs, running := q.pending[key]
+	q.pending[key] = append(jobs, job)
+	q.mu.Unlock()
+
+	if !running {
+		go q.drain(key)
+	}
+}
+
+func (q *deliveryQueue) drain(key string) {
+	for {
+		q.mu.Lock()
+		jobs := q.pending[key]
+		if len(jobs) == 0 {
+			delete(q.pending, key)
+			q.mu.Unlock()
+			return
+		}
+		job := jobs[0]
+		q.pending[key] = jobs[1:]
+		q.mu.Unlock()
+
+		job()
+	}
+}
+
+// deliverMessage sends a new message to its audience and alerts the users it
+// mentions in the background
+func deliverMessage(dbConn *gorm.DB, h *hub.Hub, from *hub.Client, message models.Message, mentions []models.Mention) {
+	deliveries.enqueue(message.SenderID, func() {
+		sendWebSocketMessage(dbConn, h, from, message)
+		notifyMentions(h, message, mentions)
+	})
+}
+
+// deliverEdit notifies the audience of an edit and alerts the users it newly
+// mentions in the background
+func deliverEdit(dbConn *gorm.DB, h *hub.Hub, message models.Message, mentions []models.Mention) {
+	deliveries.enqueue(message.SenderID, func() {
+		notifyMessageEdited(dbConn, h, message)
+		notifyMentions(h, message, mentions)
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"gorm.io/gorm"
+)
+
+// How often a comment is written to idle event streams so proxies keep them open
+const sseKeepAlive = 25 * time.Second
+
+// StreamEvents streams the authenticated user's hub frames as Server-Sent
+// Events, for clients that cannot open a WebSocket. Each event is named after
+// the frame type and carries the whole envelope as data. Like /ws it accepts
+// device_id and last_message_id query parameters.
+func StreamEvents(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+
+	client := hub.NewSubscriber(h, username, c.Query("device_id"))
+	h.Register(client)
+	defer h.Unregister(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Only this goroutine writes to the stream, so the backlog can be written
+	// straight away. Messages saved meanwhile may arrive twice.
+	if value := c.Query("last_message_id"); value != "" {
+		cursor, err := replayMessages(dbConn, username, parseCursor(value), func(frame []byte) error {
+			c.SSEvent(hub.TypeMessageNew, string(frame))
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to replay missed messages")
+		}
+		if done, err := hub.NewFrame(hub.TypeSyncDone, "", hub.SyncPayload{LastMessageID: cursor}); err == nil {
+			c.SSEvent(hub.TypeSyncDone, string(done))
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case frame, ok := <-client.Frames():
+			if !ok {
+				return false
+			}
+
+			var envelope hub.Envelope
+			if err := json.Unmarshal(frame, &envelope); err != nil {
+				log.Error().Err(err).Msg("Failed to decode hub frame")
+				return true
+			}
+			c.SSEvent(envelope.Type, string(frame))
+			return true
+
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
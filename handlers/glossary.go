@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+type glossaryRequest struct {
+	Term           string `json:"term" binding:"required"`
+	Translation    string `json:"translation"`
+	DoNotTranslate bool   `json:"do_not_translate"`
+	TargetLanguage string `json:"target_language"`
+}
+
+// Longest glossary term accepted, in characters
+const maxGlossaryTermLength = 100
+
+// GetGlossary lists the glossary of the authenticated user, or of the
+// conversation in the id path parameter for its members
+func GetGlossary(c *gin.Context, dbConn *gorm.DB) {
+	scope, ok := glossaryScope(c, dbConn, false)
+	if !ok {
+		return
+	}
+
+	entries, err := db.GetGlossary(dbConn, scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"glossary": entries})
+}
+
+// CreateGlossaryEntry adds a term to the glossary of the authenticated user,
+// or of the conversation in the id path parameter for its admins
+func CreateGlossaryEntry(c *gin.Context, dbConn *gorm.DB) {
+	scope, ok := glossaryScope(c, dbConn, true)
+	if !ok {
+		return
+	}
+
+	var request glossaryRequest
+	if !bindGlossaryRequest(c, &request) {
+		return
+	}
+
+	if !checkGlossaryTerm(c, dbConn, scope, request, 0) {
+		return
+	}
+
+	entry := models.GlossaryEntry{
+		Scope:          scope,
+		Term:           request.Term,
+		TargetLanguage: request.TargetLanguage,
+		Translation:    request.Translation,
+		DoNotTranslate: request.DoNotTranslate,
+		CreatedBy:      c.GetString("authenticated_user"),
+	}
+	if err := db.CreateGlossaryEntry(dbConn, &entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "glossary entry created successfully", "entry": entry})
+}
+
+// UpdateGlossaryEntry replaces a glossary entry, with the same rights as CreateGlossaryEntry
+func UpdateGlossaryEntry(c *gin.Context, dbConn *gorm.DB) {
+	scope, ok := glossaryScope(c, dbConn, true)
+	if !ok {
+		return
+	}
+
+	entry, ok := loadGlossaryEntry(c, dbConn, scope)
+	if !ok {
+		return
+	}
+
+	var request glossaryRequest
+	if !bindGlossaryRequest(c, &request) {
+		return
+	}
+
+	if !checkGlossaryTerm(c, dbConn, scope, request, entry.ID) {
+		return
+	}
+
+	entry.Term = request.Term
+	entry.TargetLanguage = request.TargetLanguage
+	entry.Translation = request.Translation
+	entry.DoNotTranslate = request.DoNotTranslate
+	if err := db.UpdateGlossaryEntry(dbConn, entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "glossary entry updated successfully", "entry": entry})
+}
+
+// DeleteGlossaryEntry removes a glossary entry, with the same rights as CreateGlossaryEntry
+func DeleteGlossaryEntry(c *gin.Context, dbConn *gorm.DB) {
+	scope, ok := glossaryScope(c, dbConn, true)
+	if !ok {
+		return
+	}
+
+	id, ok := glossaryEntryID(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := db.DeleteGlossaryEntry(dbConn, scope, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "glossary entry not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "glossary entry deleted successfully"})
+}
+
+// glossaryScope returns the glossary the request is about: the conversation's
+// when the route has an id, the authenticated user's otherwise. Members can
+// read a conversation glossary, only its admins can edit it.
+func glossaryScope(c *gin.Context, dbConn *gorm.DB, edit bool) (string, bool) {
+	if c.Param("id") == "" {
+		return db.UserGlossary(c.GetString("authenticated_user")), true
+	}
+
+	id, ok := conversationID(c)
+	if !ok {
+		return "", false
+	}
+
+	member, ok := requireMember(c, dbConn, id)
+	if !ok {
+		return "", false
+	}
+	if edit && !isConversationAdmin(member) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only admins can edit the conversation glossary"})
+		return "", false
+	}
+
+	return db.ConversationGlossary(id), true
+}
+
+// bindGlossaryRequest reads and normalizes a glossary entry from the request body
+func bindGlossaryRequest(c *gin.Context, request *glossaryRequest) bool {
+	if err := c.ShouldBindJSON(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	request.Term = strings.TrimSpace(request.Term)
+	request.Translation = strings.TrimSpace(request.Translation)
+	request.TargetLanguage = strings.ToLower(strings.TrimSpace(request.TargetLanguage))
+
+	if request.Term == "" || len([]rune(request.Term)) > maxGlossaryTermLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "term must be between 1 and 100 characters"})
+		return false
+	}
+	if request.DoNotTranslate {
+		request.Translation = ""
+	} else if request.Translation == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "translation is required unless do_not_translate is set"})
+		return false
+	}
+	return true
+}
+
+// checkGlossaryTerm refuses a term the glossary already has for the same language
+func checkGlossaryTerm(c *gin.Context, dbConn *gorm.DB, scope string, request glossaryRequest, exceptID uint) bool {
+	exists, err := db.GlossaryTermExists(dbConn, scope, request.Term, request.TargetLanguage, exceptID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "term is already in the glossary"})
+		return false
+	}
+	return true
+}
+
+func loadGlossaryEntry(c *gin.Context, dbConn *gorm.DB, scope string) (*models.GlossaryEntry, bool) {
+	id, ok := glossaryEntryID(c)
+	if !ok {
+		return nil, false
+	}
+
+	entry, err := db.GetGlossaryEntry(dbConn, scope, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "glossary entry not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return entry, true
+}
+
+func glossaryEntryID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("entryID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid glossary entry id"})
+		return 0, false
+	}
+	return uint(id), true
+}
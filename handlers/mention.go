@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+// Most distinct users a single message can mention
+const maxMentionsPerMessage = 20
+
+const (
+	defaultMentionPage = 50
+	maxMentionPage     = 200
+)
+
+// mentionPattern matches @username tokens that are not part of a word or an e-mail address
+var mentionPattern = regexp.MustCompile(`(^|[^\w@])@(\w[\w.\-]*)`)
+
+// parseMentions returns the distinct usernames mentioned in content, in order of appearance
+func parseMentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		username := strings.TrimRight(match[2], ".-")
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+		if len(usernames) == maxMentionsPerMessage {
+			break
+		}
+	}
+
+	return usernames
+}
+
+// saveMentions stores a mention for every existing user the message mentions
+// and can see it, and returns the ones that were not mentioned in it before.
+// It runs in the transaction saving the message so mentions are never lost.
+func saveMentions(tx *gorm.DB, message models.Message) ([]models.Mention, error) {
+	usernames := parseMentions(message.Content)
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	audience, err := messageAudience(tx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, username := range usernames {
+		if username != message.SenderID && contains(audience, username) {
+			candidates = append(candidates, username)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	users, err := db.GetUsersByUsernames(tx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []models.Mention
+	for _, user := range users {
+		mention := models.Mention{
+			MessageID:      message.ID,
+			UserName:       user.UserName,
+			SenderID:       message.SenderID,
+			ConversationID: message.ConversationID,
+		}
+		ok, err := db.AddMention(tx, &mention)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			added = append(added, mention)
+		}
+	}
+	return added, nil
+}
+
+// notifyMentions alerts the users newly mentioned in the message
+func notifyMentions(h *hub.Hub, message models.Message, mentions []models.Mention) {
+	if len(mentions) == 0 {
+		return
+	}
+
+	frame, err := hub.NewFrame(hub.TypeMention, "", hub.MentionPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Snippet:        snippet(message.Content, quoteSnippetLength),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode mention frame")
+		return
+	}
+
+	for _, mention := range mentions {
+		h.Send(mention.UserName, frame)
+	}
+}
+
+// GetMentions returns the messages mentioning the authenticated user, newest
+// first. Pass the ID of the last mention seen as before to page back.
+func GetMentions(c *gin.Context, dbConn *gorm.DB) {
+	username := c.GetString("authenticated_user")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultMentionPage)))
+	if err != nil || limit <= 0 {
+		limit = defaultMentionPage
+	}
+	if limit > maxMentionPage {
+		limit = maxMentionPage
+	}
+
+	mentions, err := db.GetMentions(dbConn, username, parseCursor(c.Query("before")), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mentions": mentions})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hey @alice", []string{"alice"}},
+		{"@alice and @bob.", []string{"alice", "bob"}},
+		{"@alice, @alice again", []string{"alice"}},
+		{"ping @john.doe-2!", []string{"john.doe-2"}},
+		{"(@carol)", []string{"carol"}},
+		{"mail alice@example.com", nil},
+		{"@@bob", nil},
+		{"no mentions", nil},
+		{"@", nil},
+	}
+
+	for _, test := range tests {
+		if got := parseMentions(test.content); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseMentions(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
+
+func TestParseMentionsCapsDistinctUsers(t *testing.T) {
+	var content []string
+	for i := 0; i < maxMentionsPerMessage+5; i++ {
+		content = append(content, fmt.Sprintf("@user%d", i))
+	}
+
+	if got := parseMentions(strings.Join(content, " ")); len(got) != maxMentionsPerMessage {
+		t.Errorf("got %d mentions, want %d", len(got), maxMentionsPerMessage)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+// Most messages that can be pinned in one chat
+const maxPinnedMessages = 10
+
+// PinMessage pins a message for every participant of its chat. In channels
+// only admins can pin.
+func PinMessage(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	updatePin(c, dbConn, h, true)
+}
+
+// UnpinMessage removes the pin of a message, with the same rights as PinMessage
+func UnpinMessage(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	updatePin(c, dbConn, h, false)
+}
+
+func updatePin(c *gin.Context, dbConn *gorm.DB, h *hub.Hub, pin bool) {
+	username := c.GetString("authenticated_user")
+
+	message, ok := loadVisibleMessage(c, dbConn)
+	if !ok {
+		return
+	}
+
+	if message.TombstonedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "message was deleted"})
+		return
+	}
+
+	if message.ConversationID != nil {
+		conversation, err := db.GetConversation(dbConn, *message.ConversationID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+			return
+		}
+		if conversation.Kind == models.ConversationChannel {
+			member, err := db.GetConversationMember(dbConn, conversation.ID, username)
+			if err != nil || !isConversationAdmin(member) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only admins can pin messages in a channel"})
+				return
+			}
+		}
+	}
+
+	chatKey := db.ChatKey(message)
+
+	var changed bool
+	var err error
+	if pin {
+		changed, err = db.PinMessage(dbConn, &models.PinnedMessage{MessageID: message.ID, ChatKey: chatKey, PinnedBy: username},
+			maxPinnedMessages)
+	} else {
+		changed, err = db.UnpinMessage(dbConn, message.ID)
+	}
+	if errors.Is(err, db.ErrTooManyPins) {
+		c.JSON(http.StatusConflict, gin.H{"error": "too many pinned messages, unpin one first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changed {
+		notifyMessageAudience(dbConn, h, *message, hub.TypePin, hub.PinPayload{
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			UserID:         username,
+			Pinned:         pin,
+		})
+	}
+
+	if pin {
+		c.JSON(http.StatusOK, gin.H{"message": "message pinned successfully"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "message unpinned successfully"})
+	}
+}
+
+// GetPinnedMessages lists the pins of the group conversation given by the
+// conversation_id query parameter, or of the direct chat with the user given
+// by the with query parameter
+func GetPinnedMessages(c *gin.Context, dbConn *gorm.DB) {
+	username := c.GetString("authenticated_user")
+
+	var chatKey string
+	if value := c.Query("conversation_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		if _, ok := requireMember(c, dbConn, uint(id)); !ok {
+			return
+		}
+		chatKey = db.ChatKey(&models.Message{ConversationID: ptr(uint(id))})
+	} else if with := c.Query("with"); with != "" {
+		chatKey = db.DirectChatKey(username, with)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "conversation_id or with is required"})
+		return
+	}
+
+	pins, err := db.GetPinnedMessages(dbConn, chatKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pins": pins})
+}
+
+// StarMessage bookmarks a message for the authenticated user
+func StarMessage(c *gin.Context, dbConn *gorm.DB) {
+	message, ok := loadVisibleMessage(c, dbConn)
+	if !ok {
+		return
+	}
+
+	star := models.StarredMessage{MessageID: message.ID, UserName: c.GetString("authenticated_user")}
+	if err := db.StarMessage(dbConn, &star); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "message starred successfully"})
+}
+
+func UnstarMessage(c *gin.Context, dbConn *gorm.DB) {
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	if err := db.UnstarMessage(dbConn, id, c.GetString("authenticated_user")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "message unstarred successfully"})
+}
+
+// GetStarredMessages lists the bookmarks of the authenticated user
+func GetStarredMessages(c *gin.Context, dbConn *gorm.DB) {
+	stars, err := db.GetStarredMessages(dbConn, c.GetString("authenticated_user"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"starred": stars})
+}
+
+// loadVisibleMessage loads the message in the id path parameter if the
+// authenticated user can see it, answering the request otherwise
+func loadVisibleMessage(c *gin.Context, dbConn *gorm.DB) (*models.Message, bool) {
+	id, ok := messageID(c)
+	if !ok {
+		return nil, false
+	}
+
+	message, err := db.GetMessageByID(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return nil, false
+	}
+
+	visible, err := canSeeMessage(dbConn, c.GetString("authenticated_user"), message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return nil, false
+	}
+
+	return message, true
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"gorm.io/gorm"
+)
+
+// Most usernames accepted by a single presence query
+const maxPresenceQuery = 200
+
+// How often a replica refreshes its presence sessions, and how long the
+// sessions of a replica that stopped refreshing them are trusted
+const (
+	presenceHeartbeat = 30 * time.Second
+	presenceExpiry    = 3 * presenceHeartbeat
+)
+
+// RunPresence records the presence changes of the hub across replicas and
+// pushes them to the conversation partners of the user. A user connected to
+// several replicas only goes offline once the last of them lets go. It runs
+// for the lifetime of the process.
+func RunPresence(dbConn *gorm.DB, h *hub.Hub) {
+	replicaID := newReplicaID()
+
+	heartbeat := time.NewTicker(presenceHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case change := <-h.PresenceChanges():
+			var changed bool
+			var err error
+			if change.Online {
+				changed, err = db.AddPresenceSession(dbConn, replicaID, change.UserID, change.At)
+			} else {
+				changed, err = db.RemovePresenceSession(dbConn, replicaID, change.UserID, change.At)
+			}
+			if err != nil {
+				log.Error().Err(err).Str("user", change.UserID).Msg("Failed to record presence")
+				continue
+			}
+			if changed {
+				notifyPresence(dbConn, h, change.UserID, change.Online, change.At)
+			}
+
+		case now := <-heartbeat.C:
+			if err := db.TouchPresenceSessions(dbConn, replicaID, now); err != nil {
+				log.Error().Err(err).Msg("Failed to refresh presence sessions")
+			}
+
+			offline, err := db.ExpirePresenceSessions(dbConn, now.Add(-presenceExpiry), now)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to expire presence sessions")
+			}
+			for _, username := range offline {
+				notifyPresence(dbConn, h, username, false, now)
+			}
+		}
+	}
+}
+
+// notifyPresence pushes a presence change to the conversation partners of the user
+func notifyPresence(dbConn *gorm.DB, h *hub.Hub, username string, online bool, at time.Time) {
+	partners, err := db.GetConversationPartners(dbConn, username)
+	if err != nil {
+		log.Error().Err(err).Str("user", username).Msg("Failed to get conversation partners")
+		return
+	}
+
+	frame, err := hub.NewFrame(hub.TypePresence, "", hub.PresencePayload{
+		UserID:   username,
+		Online:   online,
+		LastSeen: &at,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode presence frame")
+		return
+	}
+
+	for _, partner := range partners {
+		if partner != username {
+			h.Send(partner, frame)
+		}
+	}
+}
+
+// newReplicaID identifies this process among the replicas sharing the database
+func newReplicaID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GetPresence returns the presence of the comma separated usernames in the users query parameter
+func GetPresence(c *gin.Context, dbConn *gorm.DB) {
+	var usernames []string
+	for _, username := range strings.Split(c.Query("users"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+
+	if len(usernames) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing users"})
+		return
+	}
+	if len(usernames) > maxPresenceQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many users"})
+		return
+	}
+
+	users, err := db.GetUsersByUsernames(dbConn, usernames)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	presence := make([]hub.PresencePayload, 0, len(users))
+	for _, user := range users {
+		presence = append(presence, hub.PresencePayload{
+			UserID:   user.UserName,
+			Online:   user.Online,
+			LastSeen: user.LastSeen,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": presence})
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"net/http"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+// Longest emoji accepted, in bytes. Leaves room for ZWJ sequences like family emojis.
+const maxEmojiLength = 32
+
+type reactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// AddReaction adds the authenticated user's emoji reaction to a message they can see
+func AddReaction(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	var request reactionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updateReaction(c, dbConn, h, request.Emoji, true)
+}
+
+// RemoveReaction removes the authenticated user's reaction with the emoji in the path
+func RemoveReaction(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	updateReaction(c, dbConn, h, c.Param("emoji"), false)
+}
+
+func updateReaction(c *gin.Context, dbConn *gorm.DB, h *hub.Hub, emoji string, add bool) {
+	username := c.GetString("authenticated_user")
+
+	id, ok := messageID(c)
+	if !ok {
+		return
+	}
+
+	if !isEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reaction must be a single emoji"})
+		return
+	}
+
+	message, err := db.GetMessageByID(dbConn, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	visible, err := canSeeMessage(dbConn, username, message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	if message.TombstonedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "message was deleted"})
+		return
+	}
+
+	var changed bool
+	if add {
+		changed, err = db.AddReaction(dbConn, &models.Reaction{MessageID: id, UserName: username, Emoji: emoji})
+	} else {
+		changed, err = db.RemoveReaction(dbConn, id, username, emoji)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changed {
+		notifyMessageAudience(dbConn, h, *message, hub.TypeReaction, hub.ReactionPayload{
+			MessageID:      id,
+			ConversationID: message.ConversationID,
+			UserID:         username,
+			Emoji:          emoji,
+			Added:          add,
+		})
+	}
+
+	if add {
+		c.JSON(http.StatusOK, gin.H{"message": "reaction added successfully"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "reaction removed successfully"})
+	}
+}
+
+// attachReactions fills the reaction summaries of the messages as seen by viewer
+func attachReactions(dbConn *gorm.DB, viewer string, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+
+	summaries, err := db.GetReactionSummaries(dbConn, viewer, ids)
+	if err != nil {
+		return err
+	}
+
+	byMessage := make(map[uint][]models.ReactionSummary)
+	for _, summary := range summaries {
+		byMessage[summary.MessageID] = append(byMessage[summary.MessageID], summary)
+	}
+
+	for i := range messages {
+		messages[i].Reactions = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// pictographs are the code points of the Unicode Extended_Pictographic
+// property, plus the regional indicators flags are made of, leaving out ©, ®
+// and ™ which isEmoji handles itself
+var pictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x203C, Hi: 0x203C, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2139, Hi: 0x2139, Stride: 1},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21A9, Hi: 0x21AA, Stride: 1},
+		{Lo: 0x231A, Hi: 0x231B, Stride: 1},
+		{Lo: 0x2328, Hi: 0x2328, Stride: 1},
+		{Lo: 0x2388, Hi: 0x2388, Stride: 1},
+		{Lo: 0x23CF, Hi: 0x23CF, Stride: 1},
+		{Lo: 0x23E9, Hi: 0x23F3, Stride: 1},
+		{Lo: 0x23F8, Hi: 0x23FA, Stride: 1},
+		{Lo: 0x24C2, Hi: 0x24C2, Stride: 1},
+		{Lo: 0x25AA, Hi: 0x25AB, Stride: 1},
+		{Lo: 0x25B6, Hi: 0x25B6, Stride: 1},
+		{Lo: 0x25C0, Hi: 0x25C0, Stride: 1},
+		{Lo: 0x25FB, Hi: 0x25FE, Stride: 1},
+		{Lo: 0x2600, Hi: 0x2605, Stride: 1},
+		{Lo: 0x2607, Hi: 0x2612, Stride: 1},
+		{Lo: 0x2614, Hi: 0x2685, Stride: 1},
+		{Lo: 0x2690, Hi: 0x2705, Stride: 1},
+		{Lo: 0x2708, Hi: 0x2712, Stride: 1},
+		{Lo: 0x2714, Hi: 0x2714, Stride: 1},
+		{Lo: 0x2716, Hi: 0x2716, Stride: 1},
+		{Lo: 0x271D, Hi: 0x271D, Stride: 1},
+		{Lo: 0x2721, Hi: 0x2721, Stride: 1},
+		{Lo: 0x2728, Hi: 0x2728, Stride: 1},
+		{Lo: 0x2733, Hi: 0x2734, Stride: 1},
+		{Lo: 0x2744, Hi: 0x2744, Stride: 1},
+		{Lo: 0x2747, Hi: 0x2747, Stride: 1},
+		{Lo: 0x274C, Hi: 0x274C, Stride: 1},
+		{Lo: 0x274E, Hi: 0x274E, Stride: 1},
+		{Lo: 0x2753, Hi: 0x2755, Stride: 1},
+		{Lo: 0x2757, Hi: 0x2757, Stride: 1},
+		{Lo: 0x2763, Hi: 0x2767, Stride: 1},
+		{Lo: 0x2795, Hi: 0x2797, Stride: 1},
+		{Lo: 0x27A1, Hi: 0x27A1, Stride: 1},
+		{Lo: 0x27B0, Hi: 0x27B0, Stride: 1},
+		{Lo: 0x27BF, Hi: 0x27BF, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2B05, Hi: 0x2B07, Stride: 1},
+		{Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+		{Lo: 0x2B50, Hi: 0x2B50, Stride: 1},
+		{Lo: 0x2B55, Hi: 0x2B55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303D, Hi: 0x303D, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3297, Stride: 1},
+		{Lo: 0x3299, Hi: 0x3299, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1F000, Hi: 0x1F0FF, Stride: 1},
+		{Lo: 0x1F10D, Hi: 0x1F10F, Stride: 1},
+		{Lo: 0x1F12F, Hi: 0x1F12F, Stride: 1},
+		{Lo: 0x1F16C, Hi: 0x1F171, Stride: 1},
+		{Lo: 0x1F17E, Hi: 0x1F17F, Stride: 1},
+		{Lo: 0x1F18E, Hi: 0x1F18E, Stride: 1},
+		{Lo: 0x1F191, Hi: 0x1F19A, Stride: 1},
+		{Lo: 0x1F1AD, Hi: 0x1F1FF, Stride: 1},
+		{Lo: 0x1F201, Hi: 0x1F3FA, Stride: 1},
+		{Lo: 0x1F400, Hi: 0x1F53D, Stride: 1},
+		{Lo: 0x1F546, Hi: 0x1F64F, Stride: 1},
+		{Lo: 0x1F680, Hi: 0x1F6FF, Stride: 1},
+		{Lo: 0x1F774, Hi: 0x1F77F, Stride: 1},
+		{Lo: 0x1F7D5, Hi: 0x1F7FF, Stride: 1},
+		{Lo: 0x1F80C, Hi: 0x1F80F, Stride: 1},
+		{Lo: 0x1F848, Hi: 0x1F84F, Stride: 1},
+		{Lo: 0x1F85A, Hi: 0x1F85F, Stride: 1},
+		{Lo: 0x1F888, Hi: 0x1F88F, Stride: 1},
+		{Lo: 0x1F8AE, Hi: 0x1F8FF, Stride: 1},
+		{Lo: 0x1F90C, Hi: 0x1F93A, Stride: 1},
+		{Lo: 0x1F93C, Hi: 0x1F945, Stride: 1},
+		{Lo: 0x1F947, Hi: 0x1FAFF, Stride: 1},
+		{Lo: 0x1FC00, Hi: 0x1FFFD, Stride: 1},
+	},
+}
+
+// isEmoji accepts a single emoji, including skin tone, keycap, flag and ZWJ sequences
+func isEmoji(value string) bool {
+	if value == "" || len(value) > maxEmojiLength {
+		return false
+	}
+
+	runes := []rune(value)
+	symbols := 0
+	for i, r := range runes {
+		switch {
+		case r == 0x200D, r >= 0xFE00 && r <= 0xFE0F, r >= 0x1F3FB && r <= 0x1F3FF, r >= 0xE0020 && r <= 0xE007F:
+			// Joiners, variation selectors, skin tones and tag sequences
+		case r == 0x20E3:
+			symbols++
+		case r == '#' || r == '*' || (r >= '0' && r <= '9'):
+			// Only as the base of a keycap
+			if i+1 >= len(runes) || (runes[i+1] != 0x20E3 && runes[i+1] != 0xFE0F) {
+				return false
+			}
+		case r == 0xA9 || r == 0xAE || r == 0x2122:
+			// ©, ® and ™ are text unless shown as emoji
+			if i+1 >= len(runes) || runes[i+1] != 0xFE0F {
+				return false
+			}
+			symbols++
+		case unicode.Is(pictographs, r):
+			symbols++
+		default:
+			return false
+		}
+	}
+	return symbols > 0
+}
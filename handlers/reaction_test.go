@@ -0,0 +1,35 @@
+package handlers
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"❤️", true},
+		{"❤", true},
+		{"👨‍👩‍👧", true},
+		{"🇫🇷", true},
+		{"1️⃣", true},
+		{"#️⃣", true},
+		{"©️", true},
+		{"", false},
+		{"a", false},
+		{"1", false},
+		{"^", false},
+		{"`", false},
+		{"©", false},
+		{"°", false},
+		{"+1", false},
+		{"👍 ", false},
+	}
+
+	for _, test := range tests {
+		if got := isEmoji(test.value); got != test.want {
+			t.Errorf("isEmoji(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
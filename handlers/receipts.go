@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"gorm.io/gorm"
+)
+
+var errEmptyReceipt = errors.New("either message_ids, or sender_id or conversation_id and up_to_id are required")
+
+// MarkMessagesDelivered marks messages sent to the authenticated user as
+// delivered and notifies their senders
+func MarkMessagesDelivered(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	markMessages(c, dbConn, h, hub.ReceiptDelivered)
+}
+
+// MarkMessagesRead marks messages sent to the authenticated user as read and
+// notifies their senders
+func MarkMessagesRead(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	markMessages(c, dbConn, h, hub.ReceiptRead)
+}
+
+func markMessages(c *gin.Context, dbConn *gorm.DB, h *hub.Hub, kind string) {
+	username := c.GetString("authenticated_user")
+
+	var request hub.ReceiptRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids, err := applyReceipt(dbConn, h, username, kind, request)
+	if errors.Is(err, errEmptyReceipt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "messages marked as " + kind, "message_ids": ids})
+}
+
+// handleReceipt applies a receipt.delivered or receipt.read frame
+func handleReceipt(dbConn *gorm.DB, h *hub.Hub, client *hub.Client, frame hub.Envelope) {
+	var request hub.ReceiptRequest
+	if err := json.Unmarshal(frame.Payload, &request); err != nil {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrInvalidPayload, err.Error()))
+		return
+	}
+
+	kind := hub.ReceiptDelivered
+	if frame.Type == hub.TypeReceiptRead {
+		kind = hub.ReceiptRead
+	}
+
+	if _, err := applyReceipt(dbConn, h, client.UserID, kind, request); err != nil {
+		code := hub.ErrInternal
+		if errors.Is(err, errEmptyReceipt) {
+			code = hub.ErrInvalidPayload
+		}
+		h.Reply(client, hub.NewErrorFrame(frame.ID, code, err.Error()))
+	}
+}
+
+// applyReceipt stamps the selected messages of the recipient and pushes a
+// receipt frame to every sender involved and to the recipient's devices.
+// It returns the IDs of the messages that changed.
+func applyReceipt(dbConn *gorm.DB, h *hub.Hub, recipient, kind string, request hub.ReceiptRequest) ([]uint, error) {
+	if !selectsMessages(request) {
+		return nil, errEmptyReceipt
+	}
+
+	mark := db.MarkMessagesDelivered
+	if kind == hub.ReceiptRead {
+		mark = db.MarkMessagesRead
+	}
+
+	now := time.Now()
+	messages, err := mark(dbConn, recipient, request.MessageIDs, request.SenderID, request.ConversationID, request.UpToID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(messages))
+	bySender := make(map[string][]uint)
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		bySender[message.SenderID] = append(bySender[message.SenderID], message.ID)
+	}
+
+	for sender, senderIDs := range bySender {
+		frame, err := hub.NewFrame(hub.TypeReceipt, "", hub.ReceiptPayload{
+			Kind:        kind,
+			RecipientID: recipient,
+			MessageIDs:  senderIDs,
+			At:          now,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to encode receipt frame")
+			continue
+		}
+
+		h.Send(sender, frame)
+		// Keep unread counters in sync across the recipient's devices
+		if sender != recipient {
+			h.Send(recipient, frame)
+		}
+	}
+
+	return ids, nil
+}
+
+// selectsMessages reports whether the request picks messages, by ID or up to
+// an ID from a sender or in a conversation
+func selectsMessages(request hub.ReceiptRequest) bool {
+	if len(request.MessageIDs) > 0 {
+		return true
+	}
+	return request.UpToID != 0 && (request.SenderID != "" || request.ConversationID != 0)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/xvepkj/chatapp-backend/hub"
+)
+
+func TestSelectsMessages(t *testing.T) {
+	tests := []struct {
+		request hub.ReceiptRequest
+		want    bool
+	}{
+		{hub.ReceiptRequest{MessageIDs: []uint{1, 2}}, true},
+		{hub.ReceiptRequest{SenderID: "alice", UpToID: 10}, true},
+		{hub.ReceiptRequest{ConversationID: 3, UpToID: 10}, true},
+		{hub.ReceiptRequest{}, false},
+		{hub.ReceiptRequest{SenderID: "alice"}, false},
+		{hub.ReceiptRequest{ConversationID: 3}, false},
+		{hub.ReceiptRequest{UpToID: 10}, false},
+	}
+
+	for _, test := range tests {
+		if got := selectsMessages(test.request); got != test.want {
+			t.Errorf("selectsMessages(%+v) = %v, want %v", test.request, got, test.want)
+		}
+	}
+}
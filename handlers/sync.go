@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSyncTimeout = 30 * time.Second
+	maxSyncTimeout     = 60 * time.Second
+)
+
+// How long the events of a long-poll device are kept after its last poll
+const syncEventTTL = 2 * time.Minute
+
+var errPollRunning = errors.New("a poll is already running for this device")
+
+// pollSession keeps a long-poll device subscribed to the hub between its
+// polls, so the events raised meanwhile wait in its queue for the next one
+type pollSession struct {
+	client *hub.Client
+
+	// Whether a poll is reading the queue, the expiry timer runs otherwise
+	polling bool
+	expiry  *time.Timer
+}
+
+// pollSessions holds the poll sessions of this replica by user and device
+type pollSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*pollSession
+}
+
+var polls = &pollSessions{sessions: make(map[string]*pollSession)}
+
+func pollSessionKey(username, deviceID string) string {
+	return username + "\x00" + deviceID
+}
+
+// acquire returns the session of the device for a poll, subscribing a new
+// one when it has none yet or did not poll for syncEventTTL. An empty
+// deviceID always gets a new session with a random device ID.
+func (p *pollSessions) acquire(h *hub.Hub, username, deviceID string) (*pollSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if session, ok := p.sessions[pollSessionKey(username, deviceID)]; ok && deviceID != "" {
+		if session.polling {
+			return nil, errPollRunning
+		}
+		session.expiry.Stop()
+		session.polling = true
+		return session, nil
+	}
+
+	session := &pollSession{client: hub.NewPollSubscriber(h, username, deviceID), polling: true}
+	h.Register(session.client)
+	p.sessions[pollSessionKey(username, session.client.DeviceID)] = session
+	return session, nil
+}
+
+// release ends the poll of the session, keeping it subscribed for
+// syncEventTTL unless the hub dropped it
+func (p *pollSessions) release(h *hub.Hub, session *pollSession, dropped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session.polling = false
+	if dropped {
+		p.remove(h, session)
+		return
+	}
+	session.expiry = time.AfterFunc(syncEventTTL, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		// A poll may have taken the session back while the timer fired
+		if !session.polling {
+			p.remove(h, session)
+		}
+	})
+}
+
+func (p *pollSessions) remove(h *hub.Hub, session *pollSession) {
+	key := pollSessionKey(session.client.UserID, session.client.DeviceID)
+	if p.sessions[key] == session {
+		delete(p.sessions, key)
+	}
+	h.Unregister(session.client)
+}
+
+// Sync is the long-polling transport for clients that support neither
+// WebSocket nor SSE. It returns the messages for the authenticated user after
+// the since cursor, waiting up to timeout for something to happen when there
+// are none yet. Other hub events, like receipts, edits and presence, are
+// returned alongside as envelopes. They are queued for the device_id between
+// two polls for up to syncEventTTL, on the replica that served the last poll.
+// The response carries the device_id to poll with next, and reset when
+// events were lost and the client must refetch the state it depends on.
+func Sync(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username := c.GetString("authenticated_user")
+	since := parseCursor(c.Query("since"))
+
+	timeout, err := parseSyncTimeout(c.Query("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timeout"})
+		return
+	}
+
+	// Subscribe before looking at the database so nothing saved in between is missed
+	session, err := polls.acquire(h, username, c.Query("device_id"))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	open := true
+	defer func() { polls.release(h, session, !open) }()
+
+	messages, err := db.GetMessagesSince(dbConn, username, since, replayPageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var events []json.RawMessage
+	if len(messages) > 0 {
+		events, open = takeEvents(session.client)
+	} else {
+		events, open = waitForEvents(c, session.client, timeout)
+
+		messages, err = db.GetMessagesSince(dbConn, username, since, replayPageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	cursor := since
+	if len(messages) > 0 {
+		cursor = messages[len(messages)-1].ID
+	} else {
+		messages = []models.Message{}
+	}
+
+	if err := attachQuotes(dbConn, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := translateMessages(dbConn, username, messages, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":  messages,
+		"events":    events,
+		"cursor":    cursor,
+		"device_id": session.client.DeviceID,
+		"reset":     !open,
+	})
+}
+
+// waitForEvents blocks until the client receives a frame, the timeout passes or
+// the request is cancelled, then returns every frame received that is not a
+// new message, and false once the hub dropped the client. New messages are
+// read back from the database by the caller.
+func waitForEvents(c *gin.Context, client *hub.Client, timeout time.Duration) ([]json.RawMessage, bool) {
+	events := []json.RawMessage{}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-client.Frames():
+		if !ok {
+			return events, false
+		}
+		events = appendEvent(events, frame)
+	case <-timer.C:
+		return events, true
+	case <-c.Request.Context().Done():
+		return events, true
+	}
+
+	more, open := takeEvents(client)
+	return append(events, more...), open
+}
+
+// takeEvents returns the frames already queued for the client without
+// waiting, like waitForEvents
+func takeEvents(client *hub.Client) ([]json.RawMessage, bool) {
+	events := []json.RawMessage{}
+	for {
+		select {
+		case frame, ok := <-client.Frames():
+			if !ok {
+				return events, false
+			}
+			events = appendEvent(events, frame)
+		default:
+			return events, true
+		}
+	}
+}
+
+func appendEvent(events []json.RawMessage, frame []byte) []json.RawMessage {
+	var envelope hub.Envelope
+	if err := json.Unmarshal(frame, &envelope); err != nil {
+		log.Error().Err(err).Msg("Failed to decode hub frame")
+		return events
+	}
+
+	if envelope.Type == hub.TypeMessageNew {
+		return events
+	}
+	return append(events, json.RawMessage(frame))
+}
+
+// parseSyncTimeout accepts a duration like "30s" or a number of seconds
+func parseSyncTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultSyncTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	if timeout < 0 {
+		timeout = 0
+	}
+	if timeout > maxSyncTimeout {
+		timeout = maxSyncTimeout
+	}
+	return timeout, nil
+}
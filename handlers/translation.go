@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"github.com/xvepkj/chatapp-backend/utils"
+	"gorm.io/gorm"
+)
+
+// Translator renders messages in the language of each reader
+var Translator utils.Translator = utils.NewDictionaryTranslator(nil)
+
+// How long delivery, or a history request as a whole, waits on the
+// translator before falling back to the original
+const translateTimeout = 5 * time.Second
+
+// How long the translator is left alone for a language pair after it failed
+// to translate it
+const translatorRetryAfter = 30 * time.Second
+
+// Time until which the translator is not called for each language pair
+var (
+	translatorDownMu    sync.Mutex
+	translatorDownUntil = map[utils.LanguagePair]time.Time{}
+)
+
+// translatorDown reports whether the translator failed for pair less than
+// translatorRetryAfter ago
+func translatorDown(pair utils.LanguagePair) bool {
+	translatorDownMu.Lock()
+	defer translatorDownMu.Unlock()
+
+	until, ok := translatorDownUntil[pair]
+	if ok && time.Now().After(until) {
+		delete(translatorDownUntil, pair)
+		return false
+	}
+	return ok
+}
+
+func markTranslatorDown(pair utils.LanguagePair) {
+	translatorDownMu.Lock()
+	defer translatorDownMu.Unlock()
+
+	translatorDownUntil[pair] = time.Now().Add(translatorRetryAfter)
+}
+
+// userLanguages maps each of the users to their preferred language
+func userLanguages(dbConn *gorm.DB, usernames []string) (map[string]string, error) {
+	users, err := db.GetUsersByUsernames(dbConn, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	languages := make(map[string]string, len(users))
+	for _, user := range users {
+		languages[user.UserName] = user.Language
+	}
+	return languages, nil
+}
+
+// messageSource returns the language a message was written in, assuming its
+// sender's language for messages saved before it was recorded
+func messageSource(message models.Message, languages map[string]string) string {
+	if message.SourceLanguage != "" {
+		return message.SourceLanguage
+	}
+	return languages[message.SenderID]
+}
+
+// needsTranslation reports whether a message must be translated for a reader
+// whose language is target
+func needsTranslation(message models.Message, source, target string) bool {
+	return message.Content != "" && message.TombstonedAt == nil &&
+		source != "" && target != "" && source != target
+}
+
+// Returned by translateMessage when the translator is not called or returns
+// the message as written, which is then neither stored nor marked translated
+var (
+	errTranslatorDown = errors.New("translator is down")
+	errNotTranslated  = errors.New("translator left the message unchanged")
+)
+
+// translateMessage returns the content of the message in the target language
+// and stores it for next time. It fails without calling the translator while
+// it is down for the pair.
+func translateMessage(ctx context.Context, dbConn *gorm.DB, message models.Message, source, target string) (string, error) {
+	pair := utils.LanguagePair{Source: source, Target: target}
+	if translatorDown(pair) {
+		return "", errTranslatorDown
+	}
+
+	translated, err := utils.TranslateWithGlossary(ctx, Translator, message.Content, source, target,
+		messageGlossary(dbConn, message, target))
+	if err != nil {
+		log.Warn().Err(err).Str("source", source).Str("target", target).Msg("Failed to translate message")
+		// The caller running out of time says nothing about the provider
+		if ctx.Err() == nil {
+			markTranslatorDown(pair)
+		}
+		return "", err
+	}
+	// The dictionary translator without entries for the pair hands the text
+	// back as is, which must not pass for a translation
+	if translated == message.Content {
+		return "", errNotTranslated
+	}
+
+	translation := models.MessageTranslation{MessageID: message.ID, Language: target, Content: translated}
+	if err := db.SaveMessageTranslation(dbConn, &translation); err != nil {
+		log.Error().Err(err).Uint("message_id", message.ID).Msg("Failed to save message translation")
+	}
+	return translated, nil
+}
+
+// messageGlossary returns the glossary terms applying to a message translated
+// into target: its sender's, overridden by its conversation's
+func messageGlossary(dbConn *gorm.DB, message models.Message, target string) []utils.GlossaryTerm {
+	scopes := []string{db.UserGlossary(message.SenderID)}
+	if message.ConversationID != nil {
+		scopes = append(scopes, db.ConversationGlossary(*message.ConversationID))
+	}
+
+	entries, err := db.GetGlossaryTerms(dbConn, scopes, target)
+	if err != nil {
+		log.Error().Err(err).Uint("message_id", message.ID).Msg("Failed to load glossary")
+		return nil
+	}
+
+	terms := make([]utils.GlossaryTerm, len(entries))
+	for i, entry := range entries {
+		terms[i] = utils.GlossaryTerm{Term: entry.Term, Translation: entry.Translation, Keep: entry.DoNotTranslate}
+	}
+	return terms
+}
+
+// translatedFrames encodes a frame carrying a message once per reader
+// language, translating the message on first use of each language
+type translatedFrames struct {
+	dbConn    *gorm.DB
+	frameType string
+	message   models.Message
+	languages map[string]string
+
+	// original carries the message as written
+	original []byte
+	byTarget map[string][]byte
+}
+
+func newTranslatedFrames(dbConn *gorm.DB, frameType string, message models.Message, readers []string) (*translatedFrames, error) {
+	original, err := hub.NewFrame(frameType, "", message)
+	if err != nil {
+		return nil, err
+	}
+
+	languages, err := userLanguages(dbConn, readers)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get reader languages")
+	}
+
+	return &translatedFrames{
+		dbConn:    dbConn,
+		frameType: frameType,
+		message:   message,
+		languages: languages,
+		original:  original,
+		// The original already suits readers of the language the message
+		// was written in, which need not be the sender's profile language
+		byTarget: map[string][]byte{messageSource(message, languages): original},
+	}, nil
+}
+
+// forReader returns the frame in the language of the reader, the original
+// when it needs no translation or the translator fails
+func (f *translatedFrames) forReader(username string) []byte {
+	target := f.languages[username]
+	if frame, ok := f.byTarget[target]; ok {
+		return frame
+	}
+
+	frame := f.original
+	source := messageSource(f.message, f.languages)
+	if needsTranslation(f.message, source, target) {
+		translated := f.message
+		ctx, cancel := context.WithTimeout(context.Background(), translateTimeout)
+		defer cancel()
+
+		var err error
+		if translated.Content, err = translateMessage(ctx, f.dbConn, f.message, source, target); err == nil {
+			translated.TranslatedTo = target
+			if encoded, err := hub.NewFrame(f.frameType, "", translated); err == nil {
+				frame = encoded
+			}
+		}
+	}
+
+	f.byTarget[target] = frame
+	return frame
+}
+
+// translateMessages puts the content of the messages viewer did not send into
+// viewer's language, reusing stored translations. With withOriginal the
+// original text is kept in OriginalContent.
+func translateMessages(dbConn *gorm.DB, viewer string, messages []models.Message, withOriginal bool) error {
+	usernames := []string{viewer}
+	for _, message := range messages {
+		if !contains(usernames, message.SenderID) {
+			usernames = append(usernames, message.SenderID)
+		}
+	}
+
+	languages, err := userLanguages(dbConn, usernames)
+	if err != nil {
+		return err
+	}
+	target := languages[viewer]
+
+	var pending []uint
+	for _, message := range messages {
+		if message.SenderID != viewer && needsTranslation(message, messageSource(message, languages), target) {
+			pending = append(pending, message.ID)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	stored, err := db.GetMessageTranslations(dbConn, pending, target)
+	if err != nil {
+		return err
+	}
+	translations := make(map[uint]string, len(stored))
+	for _, translation := range stored {
+		translations[translation.MessageID] = translation.Content
+	}
+
+	// One deadline for the whole batch, and no more provider calls for a
+	// source language once one failed, so a slow provider cannot hold the
+	// request for every message
+	ctx, cancel := context.WithTimeout(context.Background(), translateTimeout)
+	defer cancel()
+	failedSources := map[string]bool{}
+
+	for i, message := range messages {
+		if message.SenderID == viewer || !needsTranslation(message, messageSource(message, languages), target) {
+			continue
+		}
+
+		translated, ok := translations[message.ID]
+		if !ok || translated == message.Content {
+			source := messageSource(message, languages)
+			if failedSources[source] || ctx.Err() != nil {
+				continue
+			}
+			translated, err = translateMessage(ctx, dbConn, message, source, target)
+			if errors.Is(err, errNotTranslated) {
+				continue
+			}
+			if err != nil {
+				failedSources[source] = true
+				continue
+			}
+		}
+
+		messages[i].Content = translated
+		messages[i].TranslatedTo = target
+		if withOriginal {
+			messages[i].OriginalContent = message.Content
+		}
+	}
+	return nil
+}
+
+// Users allowed to purge the translation cache
+var TranslationAdmins []string
+
+// translationStore keeps the translation cache in the database
+type translationStore struct {
+	dbConn *gorm.DB
+}
+
+// NewTranslationStore returns the persistent tier of the translation cache
+func NewTranslationStore(dbConn *gorm.DB) utils.TranslationStore {
+	return translationStore{dbConn: dbConn}
+}
+
+func (s translationStore) GetTranslation(key string) (string, bool, error) {
+	translation, err := db.GetCachedTranslation(s.dbConn, key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return translation.Translated, true, nil
+}
+
+func (s translationStore) SaveTranslation(key, text, source, target, translated string) error {
+	return db.SaveCachedTranslation(s.dbConn, &models.CachedTranslation{
+		Key:        key,
+		Source:     source,
+		Target:     target,
+		Text:       text,
+		Translated: translated,
+	})
+}
+
+func (s translationStore) PurgeTranslations(source, target string) (int64, error) {
+	return db.PurgeCachedTranslations(s.dbConn, source, target)
+}
+
+// GetTranslationCacheStats returns the hit and miss counters of the translation cache
+func GetTranslationCacheStats(c *gin.Context) {
+	cache, ok := Translator.(*utils.TranslationCache)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "translation cache is disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": cache.Stats()})
+}
+
+// PurgeTranslationCache empties the translation cache, or only its entries
+// for the source and target query parameters. Reserved to TranslationAdmins.
+func PurgeTranslationCache(c *gin.Context) {
+	if !contains(TranslationAdmins, c.GetString("authenticated_user")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only translation admins can purge the cache"})
+		return
+	}
+
+	cache, ok := Translator.(*utils.TranslationCache)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "translation cache is disabled"})
+		return
+	}
+
+	purged, err := cache.Purge(c.Query("source"), c.Query("target"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "translation cache purged", "purged": purged})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xvepkj/chatapp-backend/utils"
+)
+
+func TestTranslatorDownIsPerPair(t *testing.T) {
+	enEs := utils.LanguagePair{Source: "en", Target: "es"}
+	enFr := utils.LanguagePair{Source: "en", Target: "fr"}
+	defer func() {
+		translatorDownMu.Lock()
+		delete(translatorDownUntil, enEs)
+		translatorDownMu.Unlock()
+	}()
+
+	markTranslatorDown(enEs)
+	if !translatorDown(enEs) {
+		t.Error("en to es is not down after failing")
+	}
+	if translatorDown(enFr) {
+		t.Error("en to fr is down although only en to es failed")
+	}
+
+	translatorDownMu.Lock()
+	translatorDownUntil[enEs] = time.Now().Add(-time.Second)
+	translatorDownMu.Unlock()
+	if translatorDown(enEs) {
+		t.Error("en to es is still down after translatorRetryAfter")
+	}
+}
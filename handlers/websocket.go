@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/db"
+	"github.com/xvepkj/chatapp-backend/hub"
+	"github.com/xvepkj/chatapp-backend/models"
+	"gorm.io/gorm"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+	Subprotocols: []string{wsAuthProtocol},
+}
+
+// wsFrameHandler handles one inbound frame type
+type wsFrameHandler func(dbConn *gorm.DB, h *hub.Hub, client *hub.Client, frame hub.Envelope)
+
+// wsFrameHandlers maps inbound frame types to their handlers
+var wsFrameHandlers = map[string]wsFrameHandler{
+	hub.TypeMessageSend: handleMessageSend,
+	hub.TypeTypingStart: handleTyping,
+	hub.TypeTypingStop:  handleTyping,
+
+	hub.TypeReceiptDelivered: handleReceipt,
+	hub.TypeReceiptRead:      handleReceipt,
+}
+
+// Number of messages loaded per page when replaying missed messages
+const replayPageSize = 200
+
+// HandleWebSocket authenticates the handshake, upgrades the request to a WebSocket
+// connection and attaches it to the hub under the authenticated user.
+// Clients may pass a device_id query parameter to identify the session and a
+// last_message_id query parameter to have everything persisted after that
+// message replayed before live delivery starts.
+func HandleWebSocket(c *gin.Context, dbConn *gorm.DB, h *hub.Hub) {
+	username, err := authenticateWebSocket(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to upgrade websocket connection")
+		return
+	}
+
+	client := hub.NewClient(h, conn, username, c.Query("device_id"))
+
+	// Replay the backlog while this goroutine is still the only writer, then
+	// go live and replay whatever was persisted in between. A message saved
+	// during the switch may arrive twice, clients dedupe on its ID.
+	resume := c.Query("last_message_id") != ""
+	cursor := parseCursor(c.Query("last_message_id"))
+	if resume {
+		cursor, err = replayMessages(dbConn, username, cursor, client.Write)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to replay missed messages")
+			conn.Close()
+			return
+		}
+	}
+
+	h.Register(client)
+	go client.WritePump()
+
+	if resume {
+		cursor, err = replayMessages(dbConn, username, cursor, func(frame []byte) error {
+			h.Reply(client, frame)
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to replay missed messages")
+		}
+		if done, err := hub.NewFrame(hub.TypeSyncDone, "", hub.SyncPayload{LastMessageID: cursor}); err == nil {
+			h.Reply(client, done)
+		}
+	}
+
+	client.ReadPump(func(client *hub.Client, p []byte) error {
+		var frame hub.Envelope
+		if err := json.Unmarshal(p, &frame); err != nil {
+			h.Reply(client, hub.NewErrorFrame("", hub.ErrBadFrame, "frame is not a valid envelope"))
+			return nil
+		}
+
+		// Frames without a version are read as the current one
+		if frame.Version != 0 && frame.Version != hub.ProtocolVersion {
+			h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrUnsupportedVersion, "unsupported protocol version"))
+			return nil
+		}
+
+		handle, ok := wsFrameHandlers[frame.Type]
+		if !ok {
+			h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrUnknownType, "unknown frame type "+frame.Type))
+			return nil
+		}
+
+		handle(dbConn, h, client, frame)
+		return nil
+	})
+
+	h.ClearTyping(client)
+}
+
+// replayMessages writes every message for the user after cursor as message.new
+// frames and returns the ID of the last one written
+func replayMessages(dbConn *gorm.DB, username string, cursor uint, write func([]byte) error) (uint, error) {
+	for {
+		messages, err := db.GetMessagesSince(dbConn, username, cursor, replayPageSize)
+		if err != nil {
+			return cursor, err
+		}
+		if err := attachQuotes(dbConn, messages); err != nil {
+			return cursor, err
+		}
+		if err := translateMessages(dbConn, username, messages, false); err != nil {
+			return cursor, err
+		}
+
+		for _, message := range messages {
+			frame, err := hub.NewFrame(hub.TypeMessageNew, "", message)
+			if err != nil {
+				return cursor, err
+			}
+			if err := write(frame); err != nil {
+				return cursor, err
+			}
+			cursor = message.ID
+		}
+
+		if len(messages) < replayPageSize {
+			return cursor, nil
+		}
+	}
+}
+
+func parseCursor(value string) uint {
+	cursor, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(cursor)
+}
+
+// handleMessageSend persists a message.send frame, acks it and queues its delivery
+func handleMessageSend(dbConn *gorm.DB, h *hub.Hub, client *hub.Client, frame hub.Envelope) {
+	var receivedMessage models.Message
+	if err := json.Unmarshal(frame.Payload, &receivedMessage); err != nil {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrInvalidPayload, err.Error()))
+		return
+	}
+
+	// The connection speaks for the authenticated user only
+	if receivedMessage.SenderID != "" && receivedMessage.SenderID != client.UserID {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrForbidden, "sender does not match authenticated user"))
+		return
+	}
+	receivedMessage.SenderID = client.UserID
+
+	if err := prepareMessage(dbConn, &receivedMessage); err != nil {
+		code := hub.ErrInternal
+		if errors.Is(err, errMissingRecipient) || errors.Is(err, errInvalidReply) {
+			code = hub.ErrInvalidPayload
+		} else if errors.Is(err, errNotMember) {
+			code = hub.ErrForbidden
+		}
+		h.Reply(client, hub.NewErrorFrame(frame.ID, code, err.Error()))
+		return
+	}
+
+	mentions, err := saveMessage(dbConn, &receivedMessage)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save websocket message")
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrInternal, "failed to save message"))
+		return
+	}
+
+	if receivedMessage.ConversationID != nil {
+		h.StopTyping(client, "", *receivedMessage.ConversationID)
+	} else {
+		h.StopTyping(client, receivedMessage.ReceipientID, 0)
+	}
+
+	ack, err := hub.NewFrame(hub.TypeMessageAck, frame.ID, hub.AckPayload{
+		MessageID:      receivedMessage.ID,
+		Timestamp:      receivedMessage.Timestamp,
+		SourceLanguage: receivedMessage.SourceLanguage,
+	})
+	if err == nil {
+		h.Reply(client, ack)
+	}
+
+	deliverMessage(dbConn, h, client, receivedMessage, mentions)
+}
+
+// handleTyping turns the client's typing indicator towards a user or a group
+// conversation on or off. Indicators are relayed through the hub only, never stored.
+func handleTyping(dbConn *gorm.DB, h *hub.Hub, client *hub.Client, frame hub.Envelope) {
+	var target hub.TypingTarget
+	if err := json.Unmarshal(frame.Payload, &target); err != nil || (target.ReceipientID == "" && target.ConversationID == 0) {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrInvalidPayload, "missing recipient"))
+		return
+	}
+
+	if target.ConversationID == 0 {
+		if target.ReceipientID == client.UserID {
+			return
+		}
+		if frame.Type == hub.TypeTypingStart {
+			h.StartTyping(client, target.ReceipientID, 0)
+		} else {
+			h.StopTyping(client, target.ReceipientID, 0)
+		}
+		return
+	}
+
+	if frame.Type == hub.TypeTypingStop {
+		h.StopTyping(client, "", target.ConversationID)
+		return
+	}
+
+	members, err := db.GetConversationMemberNames(dbConn, target.ConversationID)
+	if err != nil {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrInternal, "failed to get conversation members"))
+		return
+	}
+	if !contains(members, client.UserID) {
+		h.Reply(client, hub.NewErrorFrame(frame.ID, hub.ErrForbidden, errNotMember.Error()))
+		return
+	}
+
+	for _, member := range members {
+		if member != client.UserID {
+			h.StartTyping(client, member, target.ConversationID)
+		}
+	}
+}
+
+// messageAudience returns the users who see a message: its sender and its
+// recipient, or every member of its conversation
+func messageAudience(dbConn *gorm.DB, message models.Message) ([]string, error) {
+	if message.ConversationID != nil {
+		return db.GetConversationMemberNames(dbConn, *message.ConversationID)
+	}
+	if message.SenderID == message.ReceipientID {
+		return []string{message.SenderID}, nil
+	}
+	return []string{message.SenderID, message.ReceipientID}, nil
+}
+
+// notifyMessageAudience pushes a frame about an existing message to every
+// device of everyone who sees it
+func notifyMessageAudience(dbConn *gorm.DB, h *hub.Hub, message models.Message, frameType string, payload interface{}) {
+	frame, err := hub.NewFrame(frameType, "", payload)
+	if err != nil {
+		log.Error().Err(err).Str("type", frameType).Msg("Failed to encode message frame")
+		return
+	}
+
+	audience, err := messageAudience(dbConn, message)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get message audience")
+		return
+	}
+
+	for _, username := range audience {
+		h.Send(username, frame)
+	}
+}
+
+// sendWebSocketMessage fans the message out to every session of the recipient,
+// or of every member for a group conversation, and echoes it to the sender's
+// other devices. from is the sending connection, nil when the message did not
+// arrive over the hub.
+func sendWebSocketMessage(dbConn *gorm.DB, h *hub.Hub, from *hub.Client, message models.Message) {
+	if message.ReplyToID != nil {
+		quoted := []models.Message{message}
+		if err := attachQuotes(dbConn, quoted); err != nil {
+			log.Error().Err(err).Msg("Failed to load quoted message")
+		}
+		message = quoted[0]
+	}
+
+	recipients := []string{message.ReceipientID}
+	if message.ConversationID != nil {
+		var err error
+		recipients, err = db.GetConversationMemberNames(dbConn, *message.ConversationID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get conversation members")
+			return
+		}
+	}
+
+	// Each recipient reads the message in their own language
+	frames, err := newTranslatedFrames(dbConn, hub.TypeMessageNew, message, append(recipients, message.SenderID))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal message object to JSON")
+		return
+	}
+
+	for _, recipient := range recipients {
+		if recipient != message.SenderID {
+			h.Send(recipient, frames.forReader(recipient))
+		}
+	}
+
+	if from != nil {
+		h.SendExcept(message.SenderID, from, frames.original)
+	} else {
+		h.Send(message.SenderID, frames.original)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Publication is a delivery as it travels between hub instances. It addresses
+// every connection of UserID, wherever it lives, except the connection with
+// device ExceptDevice.
+type Publication struct {
+	UserID       string          `json:"user_id"`
+	Data         json.RawMessage `json:"data"`
+	ExceptDevice string          `json:"except_device,omitempty"`
+}
+
+// Broker fans publications out to every hub instance, including the
+// publishing one, so users can be reached whichever replica they are
+// connected to
+type Broker interface {
+	// Publish hands a publication to every subscriber of every instance
+	Publish(publication Publication) error
+
+	// Subscribe registers the handler called for every publication. It is
+	// called once by the hub before it starts routing.
+	Subscribe(handler func(publication Publication))
+
+	Close() error
+}
+
+// MemoryBroker is a Broker for a single process, used when running one
+// replica and in tests
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(Publication)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(publication Publication) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(publication)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(publication Publication)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// Client is a single WebSocket connection attached to the hub. A user may
+// have several clients open at once, one per device or browser tab.
+type Client struct {
+	hub      *Hub
+	conn     *websocket.Conn
+	UserID   string
+	DeviceID string
+	send     chan []byte
+
+	// closed and closeCode are owned by the hub's Run goroutine. closeCode is
+	// read by WritePump once send is closed.
+	closed    bool
+	closeCode int
+
+	// Whether the session makes its user show as online
+	countsPresence bool
+}
+
+// NewClient wraps conn for the hub on behalf of userID. An empty deviceID
+// gets a random session ID.
+func NewClient(h *Hub, conn *websocket.Conn, userID, deviceID string) *Client {
+	if deviceID == "" {
+		deviceID = newSessionID()
+	}
+	return &Client{
+		hub:            h,
+		conn:           conn,
+		UserID:         userID,
+		DeviceID:       deviceID,
+		send:           make(chan []byte, h.config.SendQueueSize),
+		closeCode:      websocket.CloseNormalClosure,
+		countsPresence: true,
+	}
+}
+
+// NewSubscriber creates a client without a socket for transports that read
+// frames through Frames instead of WritePump, like Server-Sent Events
+func NewSubscriber(h *Hub, userID, deviceID string) *Client {
+	return NewClient(h, nil, userID, deviceID)
+}
+
+// NewPollSubscriber creates a subscriber for a single long-poll request. It
+// leaves presence alone, a polling user would otherwise flap online and
+// offline with every poll.
+func NewPollSubscriber(h *Hub, userID, deviceID string) *Client {
+	client := NewSubscriber(h, userID, deviceID)
+	client.countsPresence = false
+	return client
+}
+
+// Frames returns the client's outbound queue. It is closed once the hub drops
+// the client.
+func (c *Client) Frames() <-chan []byte {
+	return c.send
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ReadPump reads frames from the connection and passes them to handle until
+// the connection fails or handle returns an error. It must run on its own
+// goroutine per connection, it is the only reader of the connection.
+// A peer that sends neither frames nor pongs within PongWait is treated as
+// gone, which catches half-open TCP connections.
+func (c *Client) ReadPump(handle func(client *Client, data []byte) error) {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	config := c.hub.config
+	c.conn.SetReadLimit(config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	})
+
+	for {
+		_, p, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Error().Err(err).Str("user", c.UserID).Str("device", c.DeviceID).Msg("Failed to read from websocket connection")
+			} else {
+				log.Info().Str("error", err.Error()).Str("user", c.UserID).Str("device", c.DeviceID).Msg("Websocket connection closed")
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(config.PongWait))
+
+		if err := handle(c, p); err != nil {
+			log.Error().Err(err).Msg("Failed to handle websocket frame")
+			return
+		}
+	}
+}
+
+// Write sends data straight to the connection. It may only be used before
+// WritePump is started, while the caller is still the connection's only writer.
+func (c *Client) Write(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.hub.config.WriteWait))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// WritePump writes queued frames and periodic pings to the connection. It is
+// the only writer of the connection and exits once the hub closes the send
+// queue or a write fails.
+func (c *Client) WritePump() {
+	config := c.hub.config
+	ticker := time.NewTicker(config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(c.closeCode, ""),
+					time.Now().Add(config.WriteWait))
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Error().Err(err).Msg("Failed to write to websocket connection")
+				return
+			}
+
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(config.WriteWait)); err != nil {
+				log.Info().Str("error", err.Error()).Str("user", c.UserID).Str("device", c.DeviceID).Msg("Failed to ping websocket connection")
+				return
+			}
+		}
+	}
+}
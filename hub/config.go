@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvepkj/chatapp-backend/utils"
+)
+
+// Config holds the connection tuning of the hub
+type Config struct {
+	// Time allowed to write a frame to the peer
+	WriteWait time.Duration
+
+	// Time allowed between two frames or pongs from the peer before the
+	// connection is considered dead
+	PongWait time.Duration
+
+	// How often pings are sent, must be less than PongWait
+	PingInterval time.Duration
+
+	// Largest inbound frame accepted, in bytes
+	MaxMessageSize int64
+
+	// Number of outbound frames buffered per client before it is dropped
+	SendQueueSize int
+
+	// How long a typing indicator lives without being refreshed
+	TypingTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		WriteWait:      10 * time.Second,
+		PongWait:       60 * time.Second,
+		PingInterval:   54 * time.Second,
+		MaxMessageSize: 64 * 1024,
+		SendQueueSize:  256,
+		TypingTimeout:  6 * time.Second,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by the WS_WRITE_WAIT,
+// WS_PONG_WAIT, WS_PING_INTERVAL, WS_MAX_MESSAGE_SIZE, WS_SEND_QUEUE_SIZE and
+// WS_TYPING_TIMEOUT environment variables. Durations use time.ParseDuration
+// syntax, e.g. "30s".
+func ConfigFromEnv() Config {
+	config := DefaultConfig()
+	config.WriteWait = utils.EnvDuration("WS_WRITE_WAIT", config.WriteWait)
+	config.PongWait = utils.EnvDuration("WS_PONG_WAIT", config.PongWait)
+	config.PingInterval = utils.EnvDuration("WS_PING_INTERVAL", config.PingInterval)
+	config.MaxMessageSize = int64(utils.EnvInt("WS_MAX_MESSAGE_SIZE", int(config.MaxMessageSize)))
+	config.SendQueueSize = utils.EnvInt("WS_SEND_QUEUE_SIZE", config.SendQueueSize)
+	config.TypingTimeout = utils.EnvDuration("WS_TYPING_TIMEOUT", config.TypingTimeout)
+
+	if config.PingInterval >= config.PongWait {
+		log.Warn().Dur("ping_interval", config.PingInterval).Dur("pong_wait", config.PongWait).Msg("Ping interval must be below pong wait, adjusting")
+		config.PingInterval = config.PongWait * 9 / 10
+	}
+
+	return config
+}
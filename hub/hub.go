@@ -0,0 +1,256 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// Delivery is a frame addressed to every local connection of a user,
+// optionally skipping the device it originated from, or to a single connection
+type Delivery struct {
+	UserID       string
+	Data         []byte
+	ExceptDevice string
+	Client       *Client
+}
+
+// PresenceChange reports a user going online with their first session
+// counting towards presence on this replica or offline with their last
+type PresenceChange struct {
+	UserID string
+	Online bool
+	At     time.Time
+}
+
+// Hub keeps the registry of connected clients and routes frames to them.
+// The registry is only ever touched from the Run goroutine, every other
+// goroutine talks to the hub through its channels. Frames for users go
+// through the broker first so that the replica holding their connections
+// delivers them.
+type Hub struct {
+	config     Config
+	broker     Broker
+	clients    map[string]map[*Client]bool
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan *Delivery
+	typing     *typingTracker
+	presence   chan PresenceChange
+
+	// Presence changes waiting for the reader of presence
+	pendingPresence *presenceQueue
+
+	// Number of sessions counting towards presence per user, owned by Run
+	online map[string]int
+}
+
+func New(config Config, broker Broker) *Hub {
+	h := &Hub{
+		config:     config,
+		broker:     broker,
+		clients:    make(map[string]map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan *Delivery, 256),
+		presence:   make(chan PresenceChange),
+		online:     make(map[string]int),
+
+		pendingPresence: &presenceQueue{pending: make(map[string]PresenceChange), ready: make(chan struct{}, 1)},
+	}
+	h.typing = newTypingTracker(h, config.TypingTimeout)
+	go h.forwardPresence()
+
+	broker.Subscribe(func(publication Publication) {
+		h.broadcast <- &Delivery{
+			UserID:       publication.UserID,
+			Data:         publication.Data,
+			ExceptDevice: publication.ExceptDevice,
+		}
+	})
+
+	return h
+}
+
+// Run processes registrations and deliveries until the process exits
+func (h *Hub) Run() {
+	for {
+		select {
+		case client := <-h.register:
+			sessions, ok := h.clients[client.UserID]
+			if !ok {
+				sessions = make(map[*Client]bool)
+				h.clients[client.UserID] = sessions
+			}
+			sessions[client] = true
+			if client.countsPresence {
+				h.online[client.UserID]++
+				if h.online[client.UserID] == 1 {
+					h.notifyPresence(client.UserID, true)
+				}
+			}
+			log.Info().Str("user", client.UserID).Str("device", client.DeviceID).Int("sessions", len(sessions)).Msg("WebSocket client registered")
+
+		case client := <-h.unregister:
+			if h.clients[client.UserID][client] {
+				h.remove(client)
+				log.Info().Str("user", client.UserID).Str("device", client.DeviceID).Msg("WebSocket client unregistered")
+			}
+			h.closeSend(client)
+
+		case delivery := <-h.broadcast:
+			if delivery.Client != nil {
+				if h.clients[delivery.Client.UserID][delivery.Client] {
+					h.deliver(delivery.Client, delivery.Data)
+				}
+				continue
+			}
+
+			// Users connected to other replicas are not found here
+			for client := range h.clients[delivery.UserID] {
+				if delivery.ExceptDevice == "" || client.DeviceID != delivery.ExceptDevice {
+					h.deliver(client, delivery.Data)
+				}
+			}
+		}
+	}
+}
+
+// Register adds the client to the session set of its UserID
+func (h *Hub) Register(client *Client) {
+	h.register <- client
+}
+
+// Unregister removes the client from the registry if it is still registered
+func (h *Hub) Unregister(client *Client) {
+	h.unregister <- client
+}
+
+// Send queues data for delivery to every connection of the given user
+func (h *Hub) Send(userID string, data []byte) {
+	h.publish(Publication{UserID: userID, Data: data})
+}
+
+// SendExcept queues data for delivery to every connection of the given user
+// other than except, used to echo a client's own actions to its other devices
+func (h *Hub) SendExcept(userID string, except *Client, data []byte) {
+	h.publish(Publication{UserID: userID, Data: data, ExceptDevice: except.DeviceID})
+}
+
+func (h *Hub) publish(publication Publication) {
+	if err := h.broker.Publish(publication); err != nil {
+		// Still reach the users connected to this replica
+		log.Error().Err(err).Str("user", publication.UserID).Msg("Failed to publish delivery, delivering locally")
+		h.broadcast <- &Delivery{
+			UserID:       publication.UserID,
+			Data:         publication.Data,
+			ExceptDevice: publication.ExceptDevice,
+		}
+	}
+}
+
+// Reply queues data for delivery to a single connection
+func (h *Hub) Reply(client *Client, data []byte) {
+	h.broadcast <- &Delivery{Client: client, Data: data}
+}
+
+func (h *Hub) deliver(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		// The client is not keeping up, drop it rather than block the hub
+		log.Warn().Str("user", client.UserID).Str("device", client.DeviceID).Msg("WebSocket send queue full, dropping client")
+		client.closeCode = websocket.CloseTryAgainLater
+		h.remove(client)
+	}
+}
+
+// remove deletes the client from the registry and closes its send queue,
+// which makes its writer goroutine close the connection
+func (h *Hub) remove(client *Client) {
+	sessions := h.clients[client.UserID]
+	delete(sessions, client)
+	if len(sessions) == 0 {
+		delete(h.clients, client.UserID)
+	}
+	if client.countsPresence {
+		h.online[client.UserID]--
+		if h.online[client.UserID] == 0 {
+			delete(h.online, client.UserID)
+			h.notifyPresence(client.UserID, false)
+		}
+	}
+	h.closeSend(client)
+}
+
+// PresenceChanges returns the stream of presence changes, in the order they happened
+func (h *Hub) PresenceChanges() <-chan PresenceChange {
+	return h.presence
+}
+
+// notifyPresence queues a presence change without blocking the hub. Changes
+// of a user not read yet are merged into the latest one, so none is dropped
+// however far behind the reader is.
+func (h *Hub) notifyPresence(userID string, online bool) {
+	h.pendingPresence.push(PresenceChange{UserID: userID, Online: online, At: time.Now()})
+}
+
+// forwardPresence hands the queued presence changes to the reader of
+// PresenceChanges, oldest user first
+func (h *Hub) forwardPresence() {
+	for range h.pendingPresence.ready {
+		for {
+			change, ok := h.pendingPresence.pop()
+			if !ok {
+				break
+			}
+			h.presence <- change
+		}
+	}
+}
+
+// presenceQueue keeps the latest pending presence change of each user, in
+// the order the users first changed
+type presenceQueue struct {
+	mu      sync.Mutex
+	pending map[string]PresenceChange
+	order   []string
+	ready   chan struct{}
+}
+
+func (q *presenceQueue) push(change PresenceChange) {
+	q.mu.Lock()
+	if _, ok := q.pending[change.UserID]; !ok {
+		q.order = append(q.order, change.UserID)
+	}
+	q.pending[change.UserID] = change
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *presenceQueue) pop() (PresenceChange, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		return PresenceChange{}, false
+	}
+	userID := q.order[0]
+	q.order = q.order[1:]
+	change := q.pending[userID]
+	delete(q.pending, userID)
+	return change, true
+}
+
+func (h *Hub) closeSend(client *Client) {
+	if !client.closed {
+		client.closed = true
+		close(client.send)
+	}
+}
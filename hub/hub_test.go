@@ -0,0 +1,153 @@
+package hub
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := New(DefaultConfig(), NewMemoryBroker())
+	go h.Run()
+	return h
+}
+
+// receive returns the next frame queued for the client, failing after a second
+func receive(t *testing.T, client *Client) string {
+	t.Helper()
+	select {
+	case frame := <-client.Frames():
+		return string(frame)
+	case <-time.After(time.Second):
+		t.Fatalf("no frame for %s/%s", client.UserID, client.DeviceID)
+		return ""
+	}
+}
+
+// expectNothing fails if a frame reaches the client within a short while
+func expectNothing(t *testing.T, client *Client) {
+	t.Helper()
+	select {
+	case frame := <-client.Frames():
+		t.Fatalf("unexpected frame for %s/%s: %s", client.UserID, client.DeviceID, frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryBrokerFansOutToEverySubscriber(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	var first, second []Publication
+	broker.Subscribe(func(p Publication) { first = append(first, p) })
+	broker.Subscribe(func(p Publication) { second = append(second, p) })
+
+	if err := broker.Publish(Publication{UserID: "alice", Data: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != 1 || len(second) != 1 || first[0].UserID != "alice" || second[0].UserID != "alice" {
+		t.Fatalf("got %v and %v, want one publication for alice each", first, second)
+	}
+}
+
+func TestSendReachesEverySessionOfTheUser(t *testing.T) {
+	h := newTestHub(t)
+
+	phone := NewSubscriber(h, "alice", "phone")
+	laptop := NewSubscriber(h, "alice", "laptop")
+	other := NewSubscriber(h, "bob", "phone")
+	for _, client := range []*Client{phone, laptop, other} {
+		h.Register(client)
+	}
+
+	h.Send("alice", []byte(`"hello"`))
+
+	if got := receive(t, phone); got != `"hello"` {
+		t.Errorf("phone got %s", got)
+	}
+	if got := receive(t, laptop); got != `"hello"` {
+		t.Errorf("laptop got %s", got)
+	}
+	expectNothing(t, other)
+}
+
+func TestSendExceptSkipsTheOriginDevice(t *testing.T) {
+	h := newTestHub(t)
+
+	phone := NewSubscriber(h, "alice", "phone")
+	laptop := NewSubscriber(h, "alice", "laptop")
+	h.Register(phone)
+	h.Register(laptop)
+
+	h.SendExcept("alice", phone, []byte(`"echo"`))
+
+	if got := receive(t, laptop); got != `"echo"` {
+		t.Errorf("laptop got %s", got)
+	}
+	expectNothing(t, phone)
+}
+
+func TestReplyReachesOneConnection(t *testing.T) {
+	h := newTestHub(t)
+
+	phone := NewSubscriber(h, "alice", "phone")
+	laptop := NewSubscriber(h, "alice", "laptop")
+	h.Register(phone)
+	h.Register(laptop)
+
+	h.Reply(phone, []byte(`"ack"`))
+
+	if got := receive(t, phone); got != `"ack"` {
+		t.Errorf("phone got %s", got)
+	}
+	expectNothing(t, laptop)
+}
+
+func TestUnregisterClosesFrames(t *testing.T) {
+	h := newTestHub(t)
+
+	client := NewSubscriber(h, "alice", "phone")
+	h.Register(client)
+	h.Unregister(client)
+
+	select {
+	case _, ok := <-client.Frames():
+		if ok {
+			t.Fatal("got a frame, want the queue closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frames not closed after unregister")
+	}
+}
+
+func TestPresenceChangesAreNeverDropped(t *testing.T) {
+	h := newTestHub(t)
+
+	// Far more changes than anyone reads meanwhile
+	const users = 2000
+	for i := 0; i < users; i++ {
+		client := NewSubscriber(h, "user"+strconv.Itoa(i), "phone")
+		h.Register(client)
+		h.Unregister(client)
+	}
+
+	last := make(map[string]bool)
+	for len(last) < users || anyOnline(last) {
+		select {
+		case change := <-h.PresenceChanges():
+			last[change.UserID] = change.Online
+		case <-time.After(time.Second):
+			t.Fatalf("got the last change of %d users, want %d all offline", len(last), users)
+		}
+	}
+}
+
+func anyOnline(last map[string]bool) bool {
+	for _, online := range last {
+		if online {
+			return true
+		}
+	}
+	return false
+}
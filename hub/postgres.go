@@ -0,0 +1,190 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Postgres channel publications are sent on
+	notifyChannel = "chatapp_realtime"
+
+	// NOTIFY payloads are limited to 8000 bytes, larger publications are
+	// parked in the outbox table and only their row ID is notified
+	maxNotifyPayload = 7900
+
+	// How long parked publications are kept for slow listeners
+	outboxRetention = time.Minute
+)
+
+// notification is the NOTIFY payload, either the publication itself or a
+// reference to its outbox row
+type notification struct {
+	Publication *Publication `json:"p,omitempty"`
+	OutboxID    int64        `json:"ref,omitempty"`
+}
+
+// PostgresBroker is a Broker backed by Postgres LISTEN/NOTIFY, letting every
+// replica connected to the same database deliver to its own clients
+type PostgresBroker struct {
+	pool   *pgxpool.Pool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPostgresBroker connects to the database at dsn and prepares the outbox table
+func NewPostgresBroker(dsn string) (*PostgresBroker, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	_, err = pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS realtime_outbox (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		pool.Close()
+		cancel()
+		return nil, err
+	}
+
+	return &PostgresBroker{pool: pool, ctx: ctx, cancel: cancel}, nil
+}
+
+func (b *PostgresBroker) Publish(publication Publication) error {
+	payload, err := json.Marshal(notification{Publication: &publication})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		raw, err := json.Marshal(publication)
+		if err != nil {
+			return err
+		}
+
+		var id int64
+		err = b.pool.QueryRow(b.ctx, "INSERT INTO realtime_outbox (payload) VALUES ($1) RETURNING id", string(raw)).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		payload, err = json.Marshal(notification{OutboxID: id})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.pool.Exec(b.ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	return err
+}
+
+// Subscribe starts listening on a dedicated connection, reconnecting with
+// backoff whenever the connection is lost
+func (b *PostgresBroker) Subscribe(handler func(publication Publication)) {
+	go func() {
+		backoff := time.Second
+		for b.ctx.Err() == nil {
+			err := b.listen(handler)
+			if b.ctx.Err() != nil {
+				return
+			}
+			log.Error().Err(err).Dur("retry_in", backoff).Msg("Lost realtime listener connection")
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+
+	go b.pruneOutbox()
+}
+
+func (b *PostgresBroker) listen(handler func(publication Publication)) error {
+	pooled, err := b.pool.Acquire(b.ctx)
+	if err != nil {
+		return err
+	}
+	// A connection that was listening must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(b.ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	log.Info().Str("channel", notifyChannel).Msg("Listening for realtime publications")
+
+	for {
+		n, err := conn.WaitForNotification(b.ctx)
+		if err != nil {
+			return err
+		}
+
+		var received notification
+		if err := json.Unmarshal([]byte(n.Payload), &received); err != nil {
+			log.Error().Err(err).Msg("Failed to decode realtime notification")
+			continue
+		}
+
+		if received.Publication != nil {
+			handler(*received.Publication)
+			continue
+		}
+
+		publication, err := b.loadOutbox(received.OutboxID)
+		if err != nil {
+			log.Error().Err(err).Int64("outbox_id", received.OutboxID).Msg("Failed to load parked publication")
+			continue
+		}
+		handler(publication)
+	}
+}
+
+func (b *PostgresBroker) loadOutbox(id int64) (Publication, error) {
+	var publication Publication
+	if id == 0 {
+		return publication, errors.New("empty notification")
+	}
+
+	var raw string
+	err := b.pool.QueryRow(b.ctx, "SELECT payload FROM realtime_outbox WHERE id = $1", id).Scan(&raw)
+	if err != nil {
+		return publication, err
+	}
+
+	err = json.Unmarshal([]byte(raw), &publication)
+	return publication, err
+}
+
+func (b *PostgresBroker) pruneOutbox() {
+	ticker := time.NewTicker(outboxRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := b.pool.Exec(b.ctx, "DELETE FROM realtime_outbox WHERE created_at < now() - make_interval(secs => $1)", outboxRetention.Seconds())
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to prune realtime outbox")
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	b.pool.Close()
+	return nil
+}
@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is the envelope version spoken by this server
+const ProtocolVersion = 1
+
+// Frame types. Client to server frames are verbs, server to client frames
+// describe what happened.
+const (
+	TypeError = "error"
+
+	TypeMessageSend    = "message.send"
+	TypeMessageNew     = "message.new"
+	TypeMessageAck     = "message.ack"
+	TypeMessageEdited  = "message.edited"
+	TypeMessageDeleted = "message.deleted"
+	TypeSyncDone       = "sync.done"
+	TypeTypingStart    = "typing.start"
+	TypeTypingStop     = "typing.stop"
+	TypeTyping         = "typing"
+	TypePresence       = "presence"
+	TypeReaction       = "reaction"
+	TypeMention        = "mention"
+	TypePin            = "pin"
+
+	TypeConversationUpdated = "conversation.updated"
+	TypeConversationRemoved = "conversation.removed"
+
+	TypeReceiptDelivered = "receipt.delivered"
+	TypeReceiptRead      = "receipt.read"
+	TypeReceipt          = "receipt"
+)
+
+// Error codes carried by error frames
+const (
+	ErrBadFrame           = "bad_frame"
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrUnknownType        = "unknown_type"
+	ErrInvalidPayload     = "invalid_payload"
+	ErrForbidden          = "forbidden"
+	ErrInternal           = "internal"
+)
+
+// Envelope wraps every frame sent over the socket in either direction.
+// ID is chosen by the client and echoed on the ack or error for its frame.
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// AckPayload confirms a message.send once the message is persisted, with
+// the language it was detected in
+type AckPayload struct {
+	MessageID      uint      `json:"message_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	SourceLanguage string    `json:"source_language,omitempty"`
+}
+
+// SyncPayload tells a reconnecting client its replay has caught up.
+// LastMessageID is the cursor to resume from next time.
+type SyncPayload struct {
+	LastMessageID uint `json:"last_message_id"`
+}
+
+// TypingTarget addresses a typing.start or typing.stop frame to a user or
+// to a group conversation
+type TypingTarget struct {
+	ReceipientID   string `json:"receipient_id"`
+	ConversationID uint   `json:"conversation_id"`
+}
+
+// TypingPayload tells a user whether UserID is typing to them, in the group
+// conversation ConversationID if set. While typing, ExpiresIn is the number
+// of seconds after which the indicator should be dropped if no refresh arrives.
+type TypingPayload struct {
+	UserID         string `json:"user_id"`
+	ConversationID uint   `json:"conversation_id,omitempty"`
+	Typing         bool   `json:"typing"`
+	ExpiresIn      int    `json:"expires_in,omitempty"`
+}
+
+// PresencePayload tells a user that one of their conversation partners came
+// online or went offline
+type PresencePayload struct {
+	UserID   string     `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// Deletion modes
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+// MessageDeletedPayload tells clients to drop a message, or to show it as
+// deleted when Mode is DeleteForEveryone
+type MessageDeletedPayload struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID *uint  `json:"conversation_id,omitempty"`
+	Mode           string `json:"mode"`
+}
+
+// ReactionPayload tells the participants of a chat that UserID added or
+// removed a reaction to a message
+type ReactionPayload struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID *uint  `json:"conversation_id,omitempty"`
+	UserID         string `json:"user_id"`
+	Emoji          string `json:"emoji"`
+	Added          bool   `json:"added"`
+}
+
+// MentionPayload alerts a user that a message mentioned them
+type MentionPayload struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID *uint  `json:"conversation_id,omitempty"`
+	SenderID       string `json:"sender_id"`
+	Snippet        string `json:"snippet"`
+}
+
+// PinPayload tells the participants of a chat that UserID pinned or unpinned a message
+type PinPayload struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID *uint  `json:"conversation_id,omitempty"`
+	UserID         string `json:"user_id"`
+	Pinned         bool   `json:"pinned"`
+}
+
+// ConversationRemovedPayload tells a user they are no longer a member of a conversation
+type ConversationRemovedPayload struct {
+	ConversationID uint `json:"conversation_id"`
+}
+
+// Receipt kinds
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// ReceiptRequest selects the messages a receipt.delivered or receipt.read
+// frame applies to: either MessageIDs, or every message up to and including
+// UpToID from SenderID or in the conversation ConversationID
+type ReceiptRequest struct {
+	MessageIDs     []uint `json:"message_ids"`
+	SenderID       string `json:"sender_id"`
+	ConversationID uint   `json:"conversation_id"`
+	UpToID         uint   `json:"up_to_id"`
+}
+
+// ReceiptPayload tells a sender, and the recipient's other devices, that
+// messages were delivered to or read by the recipient
+type ReceiptPayload struct {
+	Kind        string    `json:"kind"`
+	RecipientID string    `json:"recipient_id"`
+	MessageIDs  []uint    `json:"message_ids"`
+	At          time.Time `json:"at"`
+}
+
+// ErrorPayload describes why a frame was rejected
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewFrame encodes payload into an envelope of the given type
+func NewFrame(frameType, id string, payload interface{}) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Envelope{
+		Version: ProtocolVersion,
+		Type:    frameType,
+		ID:      id,
+		Payload: raw,
+	})
+}
+
+// NewErrorFrame encodes an error frame answering the frame with the given id
+func NewErrorFrame(id, code, message string) []byte {
+	frame, _ := NewFrame(TypeError, id, ErrorPayload{Code: code, Message: message})
+	return frame
+}
@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// typingKey identifies one indicator: a connection typing to a user, either
+// directly or inside a group conversation
+type typingKey struct {
+	client         *Client
+	to             string
+	conversationID uint
+}
+
+// typingTracker holds the live typing indicators. Indicators are never
+// persisted and expire on their own when the client stops refreshing them,
+// e.g. because it disconnected mid-typing.
+type typingTracker struct {
+	hub    *Hub
+	ttl    time.Duration
+	mu     sync.Mutex
+	active map[typingKey]*typingEntry
+
+	// Last generation handed out, unique across indicators
+	generation uint64
+}
+
+// typingEntry is a live indicator. generation changes on every refresh so an
+// expiry timer that fired just before a refresh, or before the indicator was
+// stopped and started again, leaves the indicator alone.
+type typingEntry struct {
+	timer      *time.Timer
+	generation uint64
+}
+
+func newTypingTracker(h *Hub, ttl time.Duration) *typingTracker {
+	return &typingTracker{
+		hub:    h,
+		ttl:    ttl,
+		active: make(map[typingKey]*typingEntry),
+	}
+}
+
+func (t *typingTracker) start(key typingKey) {
+	t.mu.Lock()
+	entry, refresh := t.active[key]
+	if refresh {
+		entry.timer.Stop()
+	} else {
+		entry = &typingEntry{}
+		t.active[key] = entry
+	}
+	t.generation++
+	entry.generation = t.generation
+	generation := entry.generation
+	entry.timer = time.AfterFunc(t.ttl, func() {
+		t.expire(key, generation)
+	})
+	t.mu.Unlock()
+
+	if !refresh {
+		t.notify(key, true)
+	}
+}
+
+// expire clears an indicator unless it was refreshed since its timer was set
+func (t *typingTracker) expire(key typingKey, generation uint64) {
+	t.mu.Lock()
+	entry, ok := t.active[key]
+	if !ok || entry.generation != generation {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.active, key)
+	t.mu.Unlock()
+
+	t.notify(key, false)
+}
+
+// stop clears every indicator matched by match
+func (t *typingTracker) stop(match func(key typingKey) bool) {
+	t.mu.Lock()
+	var stopped []typingKey
+	for key, entry := range t.active {
+		if match(key) {
+			entry.timer.Stop()
+			delete(t.active, key)
+			stopped = append(stopped, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range stopped {
+		t.notify(key, false)
+	}
+}
+
+func (t *typingTracker) notify(key typingKey, typing bool) {
+	payload := TypingPayload{
+		UserID:         key.client.UserID,
+		ConversationID: key.conversationID,
+		Typing:         typing,
+	}
+	if typing {
+		payload.ExpiresIn = int(t.ttl.Seconds())
+	}
+
+	frame, err := NewFrame(TypeTyping, "", payload)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode typing frame")
+		return
+	}
+	t.hub.Send(key.to, frame)
+}
+
+// StartTyping marks the client as typing to the given user, or refreshes its
+// indicator. conversationID is set when typing in a group conversation, zero
+// otherwise. The user is notified only when the indicator turns on.
+func (h *Hub) StartTyping(client *Client, to string, conversationID uint) {
+	h.typing.start(typingKey{client: client, to: to, conversationID: conversationID})
+}
+
+// StopTyping clears the client's indicators in a conversation, towards the
+// given user or towards everyone when to is empty
+func (h *Hub) StopTyping(client *Client, to string, conversationID uint) {
+	h.typing.stop(func(key typingKey) bool {
+		return key.client == client && key.conversationID == conversationID && (to == "" || key.to == to)
+	})
+}
+
+// ClearTyping clears every indicator of a client, called when it disconnects
+func (h *Hub) ClearTyping(client *Client) {
+	h.typing.stop(func(key typingKey) bool {
+		return key.client == client
+	})
+}
@@ -1,32 +1,24 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 	"github.com/juju/ratelimit"
 	"github.com/rs/zerolog/log"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	docs "github.com/xvepkj/chatapp-backend/docs"
 	"github.com/xvepkj/chatapp-backend/handlers"
+	"github.com/xvepkj/chatapp-backend/hub"
 	"github.com/xvepkj/chatapp-backend/models"
 	"github.com/xvepkj/chatapp-backend/utils"
-	"gorm.io/gorm"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
-// Map to store WebSocket connections of users
-var userConnections = make(map[string]*websocket.Conn)
-
 // Initialize a rate limiter with a maximum of 50 requests per minute
 var limiter = ratelimit.NewBucketWithRate(60, 50)
 
@@ -54,19 +46,51 @@ func main() {
 
 	defer sqlDB.Close()
 
-	err = db.AutoMigrate(&models.User{}, &models.Message{})
+	err = db.AutoMigrate(&models.User{}, &models.Message{}, &models.Conversation{}, &models.ConversationMember{},
+		&models.MessageRevision{}, &models.HiddenMessage{}, &models.Reaction{},
+		&models.Mention{}, &models.PinnedMessage{}, &models.StarredMessage{},
+		&models.MessageTranslation{}, &models.CachedTranslation{}, &models.GlossaryEntry{},
+		&models.PresenceSession{}, &models.MessageReceipt{})
 	if err != nil {
 		panic("failed to migrate database")
 	}
 
 	docs.SwaggerInfo.BasePath = "/"
 
+	handlers.MessageEditWindow = utils.EnvDuration("MESSAGE_EDIT_WINDOW", handlers.MessageEditWindow)
+
+	// Translations are cached in memory and in the database, in front of
+	// the provider picked by TRANSLATOR
+	handlers.Translator = utils.NewTranslationCache(utils.TranslatorFromEnv(), handlers.NewTranslationStore(db),
+		utils.EnvInt("TRANSLATION_CACHE_SIZE", 10000))
+	if admins := os.Getenv("TRANSLATION_ADMINS"); admins != "" {
+		handlers.TranslationAdmins = strings.Split(admins, ",")
+	}
+
+	// Realtime backplane shared by all replicas, REALTIME_BROKER=memory
+	// keeps delivery inside this process
+	var broker hub.Broker
+	if os.Getenv("REALTIME_BROKER") == "memory" {
+		broker = hub.NewMemoryBroker()
+	} else {
+		broker, err = hub.NewPostgresBroker(utils.DSN)
+		if err != nil {
+			panic("failed to start realtime broker")
+		}
+	}
+	defer broker.Close()
+
+	// Hub owning all WebSocket connections
+	wsHub := hub.New(hub.ConfigFromEnv(), broker)
+	go wsHub.Run()
+	go handlers.RunPresence(db, wsHub)
+
 	router := gin.Default()
 
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"}, // Replace with your frontend URL
 		AllowCredentials: true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"*"},
 	})
 
@@ -91,7 +115,63 @@ func main() {
 	})
 
 	router.POST("/messages", authMiddleware, func(c *gin.Context) {
-		handlers.AddMessage(c, db)
+		handlers.AddMessage(c, db, wsHub)
+	})
+
+	router.POST("/messages/delivered", authMiddleware, func(c *gin.Context) {
+		handlers.MarkMessagesDelivered(c, db, wsHub)
+	})
+
+	router.POST("/messages/read", authMiddleware, func(c *gin.Context) {
+		handlers.MarkMessagesRead(c, db, wsHub)
+	})
+
+	router.PATCH("/messages/:id", authMiddleware, func(c *gin.Context) {
+		handlers.EditMessage(c, db, wsHub)
+	})
+
+	router.DELETE("/messages/:id", authMiddleware, func(c *gin.Context) {
+		handlers.DeleteMessage(c, db, wsHub)
+	})
+
+	router.POST("/messages/:id/reactions", authMiddleware, func(c *gin.Context) {
+		handlers.AddReaction(c, db, wsHub)
+	})
+
+	router.DELETE("/messages/:id/reactions/:emoji", authMiddleware, func(c *gin.Context) {
+		handlers.RemoveReaction(c, db, wsHub)
+	})
+
+	router.POST("/messages/:id/pin", authMiddleware, func(c *gin.Context) {
+		handlers.PinMessage(c, db, wsHub)
+	})
+
+	router.DELETE("/messages/:id/pin", authMiddleware, func(c *gin.Context) {
+		handlers.UnpinMessage(c, db, wsHub)
+	})
+
+	router.GET("/pins", authMiddleware, func(c *gin.Context) {
+		handlers.GetPinnedMessages(c, db)
+	})
+
+	router.POST("/messages/:id/star", authMiddleware, func(c *gin.Context) {
+		handlers.StarMessage(c, db)
+	})
+
+	router.DELETE("/messages/:id/star", authMiddleware, func(c *gin.Context) {
+		handlers.UnstarMessage(c, db)
+	})
+
+	router.GET("/starred", authMiddleware, func(c *gin.Context) {
+		handlers.GetStarredMessages(c, db)
+	})
+
+	router.GET("/threads/:id", authMiddleware, func(c *gin.Context) {
+		handlers.GetThread(c, db)
+	})
+
+	router.GET("/revisions/:id", authMiddleware, func(c *gin.Context) {
+		handlers.GetMessageRevisions(c, db)
 	})
 
 	router.GET("/messages/:senderID/:receiverID", authMiddleware, func(c *gin.Context) {
@@ -102,14 +182,124 @@ func main() {
 		handlers.ExportMessagesToExcel(c, db)
 	})
 
+	router.POST("/ws/ticket", authMiddleware, handlers.IssueWebSocketTicket)
+
+	router.POST("/conversations", authMiddleware, func(c *gin.Context) {
+		handlers.CreateConversation(c, db, wsHub)
+	})
+
+	router.GET("/conversations", authMiddleware, func(c *gin.Context) {
+		handlers.GetConversations(c, db)
+	})
+
+	router.GET("/conversations/:id", authMiddleware, func(c *gin.Context) {
+		handlers.GetConversation(c, db)
+	})
+
+	router.PUT("/conversations/:id", authMiddleware, func(c *gin.Context) {
+		handlers.RenameConversation(c, db, wsHub)
+	})
+
+	router.GET("/conversations/:id/messages", authMiddleware, func(c *gin.Context) {
+		handlers.GetConversationMessages(c, db)
+	})
+
+	router.POST("/conversations/:id/members", authMiddleware, func(c *gin.Context) {
+		handlers.AddConversationMember(c, db, wsHub)
+	})
+
+	router.DELETE("/conversations/:id/members/:username", authMiddleware, func(c *gin.Context) {
+		handlers.RemoveConversationMember(c, db, wsHub)
+	})
+
+	router.POST("/conversations/:id/leave", authMiddleware, func(c *gin.Context) {
+		handlers.LeaveConversation(c, db, wsHub)
+	})
+
+	router.POST("/conversations/:id/invites", authMiddleware, func(c *gin.Context) {
+		handlers.CreateInvite(c, db)
+	})
+
+	router.POST("/invites/:token/join", authMiddleware, func(c *gin.Context) {
+		handlers.JoinByInvite(c, db, wsHub)
+	})
+
+	router.POST("/channels", authMiddleware, func(c *gin.Context) {
+		handlers.CreateChannel(c, db, wsHub)
+	})
+
+	router.GET("/channels", authMiddleware, func(c *gin.Context) {
+		handlers.SearchChannels(c, db)
+	})
+
+	router.POST("/channels/:id/join", authMiddleware, func(c *gin.Context) {
+		handlers.JoinChannel(c, db, wsHub)
+	})
+
 	router.GET("/ws", func(c *gin.Context) {
-		handleWebSocketConnection(c, db)
+		handlers.HandleWebSocket(c, db, wsHub)
+	})
+
+	router.GET("/events", authMiddleware, func(c *gin.Context) {
+		handlers.StreamEvents(c, db, wsHub)
+	})
+
+	router.GET("/sync", authMiddleware, func(c *gin.Context) {
+		handlers.Sync(c, db, wsHub)
 	})
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	router.GET("/validate-token", ValidateTokenHandler)
 
+	router.GET("/mentions", authMiddleware, func(c *gin.Context) {
+		handlers.GetMentions(c, db)
+	})
+
+	router.GET("/glossary", authMiddleware, func(c *gin.Context) {
+		handlers.GetGlossary(c, db)
+	})
+
+	router.POST("/glossary", authMiddleware, func(c *gin.Context) {
+		handlers.CreateGlossaryEntry(c, db)
+	})
+
+	router.PUT("/glossary/:entryID", authMiddleware, func(c *gin.Context) {
+		handlers.UpdateGlossaryEntry(c, db)
+	})
+
+	router.DELETE("/glossary/:entryID", authMiddleware, func(c *gin.Context) {
+		handlers.DeleteGlossaryEntry(c, db)
+	})
+
+	router.GET("/conversations/:id/glossary", authMiddleware, func(c *gin.Context) {
+		handlers.GetGlossary(c, db)
+	})
+
+	router.POST("/conversations/:id/glossary", authMiddleware, func(c *gin.Context) {
+		handlers.CreateGlossaryEntry(c, db)
+	})
+
+	router.PUT("/conversations/:id/glossary/:entryID", authMiddleware, func(c *gin.Context) {
+		handlers.UpdateGlossaryEntry(c, db)
+	})
+
+	router.DELETE("/conversations/:id/glossary/:entryID", authMiddleware, func(c *gin.Context) {
+		handlers.DeleteGlossaryEntry(c, db)
+	})
+
+	router.GET("/translations/cache", authMiddleware, func(c *gin.Context) {
+		handlers.GetTranslationCacheStats(c)
+	})
+
+	router.DELETE("/translations/cache", authMiddleware, func(c *gin.Context) {
+		handlers.PurgeTranslationCache(c)
+	})
+
+	router.GET("/presence", authMiddleware, func(c *gin.Context) {
+		handlers.GetPresence(c, db)
+	})
+
 	router.GET("/friends", authMiddleware, func(c *gin.Context) {
 		handlers.GetUsersSentTo(c, db)
 	})
@@ -194,84 +384,3 @@ func authMiddleware(c *gin.Context) {
 	// Continue to the next middleware or route handler
 	c.Next()
 }
-
-func sendWebSocketMessage(message models.Message) {
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal message object to JSON")
-		return
-	}
-
-	log.Info().Msg(string(messageJSON))
-
-	// Find recipient's WebSocket connection
-	recipientConn := userConnections[message.ReceipientID]
-	if recipientConn != nil {
-		log.Info().Str("recipient_connection_address", recipientConn.RemoteAddr().String()).Msg("WebSocket connection for recipient")
-		// Convert message to JSON
-		messageJSON, err := json.Marshal(message)
-		if err != nil {
-			// Handle error
-			return
-		}
-
-		// Send message to recipient
-		err = recipientConn.WriteMessage(websocket.TextMessage, messageJSON)
-		if err != nil {
-			log.Info().Str("Error write message", err.Error())
-			// Handle error
-			return
-		}
-	} else {
-		log.Info().Msg("Receipient not found")
-		// Handle case where recipient is not found
-		return
-	}
-}
-
-func handleWebSocketConnection(c *gin.Context, db *gorm.DB) {
-	log.Info().Msg("Entered handleWebSocketConnection")
-	// Upgrade HTTP connection to WebSocket
-	var upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
-	log.Info().Msg("Entered step 2")
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		// Handle error
-		return
-	}
-	defer conn.Close()
-
-	log.Info().Msg("Entered step 3")
-	// Read messages from WebSocket connection
-	for {
-		_, p, err := conn.ReadMessage()
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
-				log.Info().Str("error", err.Error()).Msg("Websocket connection closed by client")
-				break
-			}
-		}
-
-		// Process received message
-		var receivedMessage models.Message
-		err = json.Unmarshal(p, &receivedMessage)
-		if err != nil {
-			// Handle error
-			break
-		}
-
-		handlers.AddMessageWebSocket(db, &receivedMessage)
-
-		// Add user connection to map if not already present
-		userConnections[receivedMessage.SenderID] = conn
-
-		// Broadcast message to recipient
-		sendWebSocketMessage(receivedMessage)
-	}
-}
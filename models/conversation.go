@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of conversation
+const (
+	ConversationGroup   = "group"
+	ConversationChannel = "channel"
+)
+
+// Roles of a conversation member
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type Conversation struct {
+	gorm.Model
+	Name      string
+	CreatedBy string
+
+	// Kind is ConversationGroup or ConversationChannel. Public channels can be
+	// found and joined by anyone, everything else needs an invite.
+	Kind        string `gorm:"default:group"`
+	Public      bool   `gorm:"index"`
+	Description string
+
+	// Filled by listing queries only
+	MemberCount int64 `gorm:"->;-:migration"`
+
+	Members []ConversationMember
+}
+
+type ConversationMember struct {
+	ConversationID uint   `gorm:"primaryKey"`
+	UserName       string `gorm:"primaryKey;index"`
+	Role           string
+	JoinedAt       time.Time `gorm:"autoCreateTime"`
+}
@@ -12,4 +12,129 @@ type Message struct {
 	ReceipientID string
 	Content      string
 	Timestamp    time.Time `gorm:"autoCreateTime"`
+
+	// Language Content was written in, detected on save or else assumed to
+	// be the sender's
+	SourceLanguage string
+
+	// Set when Content was translated for the user asking: the language it
+	// is now in, and the original text when they asked for it
+	TranslatedTo    string `gorm:"-"`
+	OriginalContent string `gorm:"-"`
+
+	// Set instead of ReceipientID for messages to a group conversation
+	ConversationID *uint `gorm:"index"`
+
+	// Set on replies to the message they answer
+	ReplyToID *uint `gorm:"index"`
+
+	// Filled by history queries only
+	ReplyCount int64 `gorm:"->;-:migration"`
+
+	// Filled for realtime delivery of replies
+	Quote *MessageQuote `gorm:"-"`
+
+	// Filled by history queries for the user asking
+	Reactions []ReactionSummary `gorm:"-"`
+
+	// Receipts, set once by the recipient
+	DeliveredAt *time.Time
+	ReadAt      *time.Time
+
+	// Set when the sender last edited the content
+	EditedAt *time.Time
+
+	// Set when the message was deleted for everyone. The content is wiped and
+	// the message stays in the history as a placeholder.
+	TombstonedAt *time.Time
+}
+
+// MessageQuote is the excerpt of the parent message shown above a reply
+type MessageQuote struct {
+	ID       uint
+	SenderID string
+	Snippet  string
+	Deleted  bool
+}
+
+// Reaction is one user's emoji reaction to a message
+type Reaction struct {
+	MessageID uint      `gorm:"primaryKey"`
+	UserName  string    `gorm:"primaryKey"`
+	Emoji     string    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// ReactionSummary aggregates the reactions to a message with one emoji
+type ReactionSummary struct {
+	MessageID   uint `json:"-"`
+	Emoji       string
+	Count       int64
+	ReactedByMe bool
+}
+
+// Mention records that a message called out a user with @username
+type Mention struct {
+	ID             uint   `gorm:"primaryKey"`
+	MessageID      uint   `gorm:"uniqueIndex:idx_mention_message_user"`
+	UserName       string `gorm:"uniqueIndex:idx_mention_message_user;index"`
+	SenderID       string
+	ConversationID *uint
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+
+	Message Message
+}
+
+// PinnedMessage is a message pinned to the top of its chat for every participant.
+// ChatKey identifies the chat, see db.ChatKey.
+type PinnedMessage struct {
+	MessageID uint   `gorm:"primaryKey"`
+	ChatKey   string `gorm:"index"`
+	PinnedBy  string
+	PinnedAt  time.Time `gorm:"autoCreateTime"`
+
+	Message Message
+}
+
+// StarredMessage is a message bookmarked by one user for themselves
+type StarredMessage struct {
+	MessageID uint      `gorm:"primaryKey"`
+	UserName  string    `gorm:"primaryKey;index"`
+	StarredAt time.Time `gorm:"autoCreateTime"`
+
+	Message Message
+}
+
+// HiddenMessage hides a message from one user only, after they deleted it for themselves
+type HiddenMessage struct {
+	MessageID uint      `gorm:"primaryKey"`
+	UserName  string    `gorm:"primaryKey"`
+	HiddenAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// MessageReceipt records when a message of a group conversation or channel
+// was delivered to and read by one of its members. Direct messages keep
+// their receipts on the message itself.
+type MessageReceipt struct {
+	MessageID   uint   `gorm:"primaryKey"`
+	UserName    string `gorm:"primaryKey"`
+	DeliveredAt *time.Time
+	ReadAt      *time.Time
+}
+
+// MessageTranslation is the content of a message translated into one
+// language, kept so a message is never translated twice
+type MessageTranslation struct {
+	MessageID uint   `gorm:"primaryKey"`
+	Language  string `gorm:"primaryKey"`
+	Content   string
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// MessageRevision keeps the content a message had before one of its edits
+type MessageRevision struct {
+	ID        uint `gorm:"primaryKey"`
+	MessageID uint `gorm:"index"`
+	Content   string
+	EditedAt  time.Time
 }
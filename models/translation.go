@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// CachedTranslation is a translation kept by the translation cache, keyed by
+// utils.TranslationCacheKey
+type CachedTranslation struct {
+	Key        string `gorm:"primaryKey"`
+	Source     string `gorm:"index"`
+	Target     string `gorm:"index"`
+	Text       string
+	Translated string
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// GlossaryEntry fixes how a term is translated in the messages of a user or
+// of a group conversation, see db.UserGlossary and db.ConversationGlossary.
+// TargetLanguage limits the entry to one language, any when empty.
+type GlossaryEntry struct {
+	ID             uint   `gorm:"primaryKey"`
+	Scope          string `gorm:"index"`
+	Term           string
+	TargetLanguage string
+	Translation    string
+	DoNotTranslate bool
+	CreatedBy      string
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
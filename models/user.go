@@ -1,11 +1,26 @@
 package models
 
+import "time"
+
 type User struct {
 	UserName string `gorm:"primaryKey;unique"`
 	Password string
 	Language string
 	Token    string `gorm:"-"`
 
+	// Presence, maintained from the WebSocket connection lifecycle
+	Online   bool
+	LastSeen *time.Time
+
 	SentMessages     []Message `gorm:"foreignKey:SenderID"`
 	ReceivedMessages []Message `gorm:"foreignKey:ReceipientID"`
 }
+
+// PresenceSession records that a replica holds at least one session of a
+// user. A user is online while any replica does. SeenAt is refreshed by the
+// replica's heartbeat so the rows of a crashed replica expire.
+type PresenceSession struct {
+	ReplicaID string `gorm:"primaryKey"`
+	UserName  string `gorm:"primaryKey;index"`
+	SeenAt    time.Time
+}
@@ -7,9 +7,11 @@ import (
 	"gorm.io/gorm"
 )
 
+// DSN is the connection string of the application database
+const DSN = "user=chatuser password=yoyoyoyoyo dbname=chatapp port=5432 sslmode=disable"
+
 func ConnectDB() (*gorm.DB, error) {
-	dsn := "user=chatuser password=yoyoyoyoyo dbname=chatapp port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(DSN), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
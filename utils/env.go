@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EnvDuration reads a positive duration like "30s" from the environment,
+// falling back when the variable is unset or invalid
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Warn().Str("key", key).Str("value", value).Msg("Ignoring invalid duration")
+		return fallback
+	}
+	return d
+}
+
+// EnvInt reads a positive number from the environment, falling back when the
+// variable is unset or invalid
+func EnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Warn().Str("key", key).Str("value", value).Msg("Ignoring invalid number")
+		return fallback
+	}
+	return n
+}
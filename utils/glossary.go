@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GlossaryTerm fixes how a term is translated. With Keep the term is left as
+// written, otherwise it always becomes Translation.
+type GlossaryTerm struct {
+	Term        string
+	Translation string
+	Keep        bool
+}
+
+// glossaryPlaceholder matches the placeholders standing in for glossary terms
+// during translation, tolerating the spaces some providers add
+var glossaryPlaceholder = regexp.MustCompile(`\[\[\s*(\d+)\s*\]\]`)
+
+// TranslateWithGlossary translates text with translator, replacing every
+// whole-word, case-insensitive occurrence of a glossary term with a
+// placeholder so the provider cannot mangle it, then putting the fixed
+// translation or the original term back. Longer terms win over shorter ones.
+func TranslateWithGlossary(ctx context.Context, translator Translator, text, source, target string, terms []GlossaryTerm) (string, error) {
+	protected, replacements := protectTerms(text, terms)
+	if len(replacements) == 0 {
+		return translator.Translate(ctx, text, source, target)
+	}
+
+	translated, err := translator.Translate(ctx, protected, source, target)
+	if err != nil {
+		return "", err
+	}
+
+	return glossaryPlaceholder.ReplaceAllStringFunc(translated, func(placeholder string) string {
+		i, err := strconv.Atoi(glossaryPlaceholder.FindStringSubmatch(placeholder)[1])
+		if err != nil || i >= len(replacements) {
+			return placeholder
+		}
+		return replacements[i]
+	}), nil
+}
+
+// protectTerms swaps the glossary terms in text for numbered placeholders and
+// returns what each placeholder must become
+func protectTerms(text string, terms []GlossaryTerm) (string, []string) {
+	byTerm := make(map[string]GlossaryTerm, len(terms))
+	var alternatives []string
+	for _, term := range terms {
+		key := strings.ToLower(strings.TrimSpace(term.Term))
+		if key == "" {
+			continue
+		}
+		if _, ok := byTerm[key]; !ok {
+			alternatives = append(alternatives, regexp.QuoteMeta(key))
+		}
+		byTerm[key] = term
+	}
+	if len(alternatives) == 0 {
+		return text, nil
+	}
+
+	sort.Slice(alternatives, func(i, j int) bool { return len(alternatives[i]) > len(alternatives[j]) })
+	pattern := regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|"))
+
+	var builder strings.Builder
+	var replacements []string
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		if !isWordBoundary(text, start, end) {
+			continue
+		}
+
+		term, ok := byTerm[strings.ToLower(text[start:end])]
+		if !ok {
+			continue
+		}
+		replacement := term.Translation
+		if term.Keep {
+			replacement = text[start:end]
+		}
+
+		builder.WriteString(text[last:start])
+		builder.WriteString("[[" + strconv.Itoa(len(replacements)) + "]]")
+		replacements = append(replacements, replacement)
+		last = end
+	}
+	builder.WriteString(text[last:])
+
+	return builder.String(), replacements
+}
+
+// isWordBoundary reports whether text[start:end] is not part of a longer word
+func isWordBoundary(text string, start, end int) bool {
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordChar(before) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordChar(after) {
+		return false
+	}
+	return true
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
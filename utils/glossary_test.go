@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProtectTerms(t *testing.T) {
+	terms := []GlossaryTerm{
+		{Term: "Go", Keep: true},
+		{Term: "go team", Translation: "equipo Go"},
+		{Term: "ChatApp", Keep: true},
+		{Term: "café", Translation: "coffee"},
+	}
+
+	tests := []struct {
+		text         string
+		want         string
+		replacements []string
+	}{
+		{"We use Go", "We use [[0]]", []string{"Go"}},
+		{"the GO team ships", "the [[0]] ships", []string{"equipo Go"}},
+		{"Gopher and ago stay", "Gopher and ago stay", nil},
+		{"chatapp, ChatApp!", "[[0]], [[1]]!", []string{"chatapp", "ChatApp"}},
+		{"un café_noir, un café.", "un café_noir, un [[0]].", []string{"coffee"}},
+		{"nothing here", "nothing here", nil},
+	}
+
+	for _, test := range tests {
+		got, replacements := protectTerms(test.text, terms)
+		if got != test.want || strings.Join(replacements, "|") != strings.Join(test.replacements, "|") {
+			t.Errorf("protectTerms(%q) = %q %q, want %q %q", test.text, got, replacements, test.want, test.replacements)
+		}
+	}
+}
+
+func TestProtectTermsLaterEntriesWin(t *testing.T) {
+	_, replacements := protectTerms("deploy", []GlossaryTerm{
+		{Term: "deploy", Translation: "desplegar"},
+		{Term: "Deploy", Keep: true},
+	})
+	if len(replacements) != 1 || replacements[0] != "deploy" {
+		t.Errorf("got %q, want the later do-not-translate entry to win", replacements)
+	}
+}
+
+// spacingTranslator translates like a provider that pads brackets with spaces
+type spacingTranslator struct{}
+
+func (spacingTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	text = strings.ReplaceAll(text, "[[", "[[ ")
+	return strings.ReplaceAll(text, "the", "el"), nil
+}
+
+func TestTranslateWithGlossary(t *testing.T) {
+	dictionary := NewDictionaryTranslator(map[LanguagePair]map[string]string{
+		{Source: "en", Target: "es"}: {"the": "el", "go": "ir", "team": "equipo", "uses": "usa"},
+	})
+	terms := []GlossaryTerm{{Term: "go", Keep: true}, {Term: "go team", Translation: "equipo Go"}}
+
+	tests := []struct {
+		translator Translator
+		text       string
+		want       string
+	}{
+		{dictionary, "The team uses Go, not Gopher. the GO team", "el equipo usa Go, not Gopher. el equipo Go"},
+		{dictionary, "the team", "el equipo"},
+		{spacingTranslator{}, "the go team", "el equipo Go"},
+	}
+
+	for _, test := range tests {
+		got, err := TranslateWithGlossary(context.Background(), test.translator, test.text, "en", "es", terms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("TranslateWithGlossary(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
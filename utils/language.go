@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Texts with fewer letters than this are too short to tell languages apart
+const minDetectLetters = 12
+
+// Number of most frequent trigrams kept in a language profile
+const profileSize = 300
+
+// Sample text the trigram profiles of the Latin script languages are built from
+var languageSamples = map[string]string{
+	"en": `the quick brown fox jumps over the lazy dog. hello, how are you doing today?
+		i think we should meet tomorrow morning at the office to talk about the project.
+		thank you very much for your help, it was really nice to see you again.
+		what time is it? where are you going this weekend? let me know when you are ready.
+		this is the best thing that has happened to us in a long time and we are happy with it.
+		could you please send me the report before the end of the day, i would like to read it`,
+	"es": `el rápido zorro marrón salta sobre el perro perezoso. hola, ¿cómo estás hoy?
+		creo que deberíamos reunirnos mañana por la mañana en la oficina para hablar del proyecto.
+		muchas gracias por tu ayuda, fue muy agradable verte de nuevo.
+		¿qué hora es? ¿adónde vas este fin de semana? avísame cuando estés listo.
+		esto es lo mejor que nos ha pasado en mucho tiempo y estamos contentos con ello.
+		por favor envíame el informe antes del final del día, me gustaría leerlo
+		¿puedes llamarme cuando llegues a casa esta noche? nos vemos luego, cuídate mucho`,
+	"fr": `le renard brun rapide saute par-dessus le chien paresseux. bonjour, comment vas-tu aujourd'hui ?
+		je pense que nous devrions nous retrouver demain matin au bureau pour parler du projet.
+		merci beaucoup pour ton aide, c'était vraiment agréable de te revoir.
+		quelle heure est-il ? où vas-tu ce week-end ? dis-moi quand tu es prêt.
+		c'est la meilleure chose qui nous soit arrivée depuis longtemps et nous en sommes contents.
+		peux-tu m'envoyer le rapport avant la fin de la journée, je voudrais le lire`,
+	"de": `der schnelle braune fuchs springt über den faulen hund. hallo, wie geht es dir heute?
+		ich denke, wir sollten uns morgen früh im büro treffen, um über das projekt zu sprechen.
+		vielen dank für deine hilfe, es war wirklich schön, dich wiederzusehen.
+		wie spät ist es? wohin fährst du an diesem wochenende? sag mir bescheid, wenn du fertig bist.
+		das ist das beste, was uns seit langer zeit passiert ist, und wir sind damit zufrieden.
+		kannst du mir bitte den bericht vor dem ende des tages schicken, ich möchte ihn lesen`,
+	"it": `la veloce volpe marrone salta sopra il cane pigro. ciao, come stai oggi?
+		penso che dovremmo incontrarci domani mattina in ufficio per parlare del progetto.
+		grazie mille per il tuo aiuto, è stato davvero bello rivederti.
+		che ore sono? dove vai questo fine settimana? fammi sapere quando sei pronto.
+		questa è la cosa migliore che ci sia successa da molto tempo e ne siamo contenti.
+		per favore mandami il rapporto prima della fine della giornata, vorrei leggerlo`,
+	"pt": `a rápida raposa marrom pula sobre o cão preguiçoso. olá, como você está hoje?
+		acho que devíamos nos encontrar amanhã de manhã no escritório para falar sobre o projeto.
+		muito obrigado pela sua ajuda, foi muito bom ver você de novo.
+		que horas são? aonde você vai neste fim de semana? me avise quando estiver pronto.
+		esta é a melhor coisa que nos aconteceu em muito tempo e estamos felizes com isso.
+		por favor me envie o relatório antes do final do dia, eu gostaria de lê-lo`,
+	"nl": `de snelle bruine vos springt over de luie hond. hallo, hoe gaat het vandaag met je?
+		ik denk dat we morgenochtend op kantoor moeten afspreken om over het project te praten.
+		heel erg bedankt voor je hulp, het was echt leuk om je weer te zien.
+		hoe laat is het? waar ga je dit weekend naartoe? laat me weten wanneer je klaar bent.
+		dit is het beste wat ons in lange tijd is overkomen en we zijn er blij mee.
+		kun je me alsjeblieft het verslag sturen voor het einde van de dag, ik wil het graag lezen`,
+}
+
+// Languages recognised by their script alone
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// languageProfiles ranks the trigrams of each sample, most frequent first
+var languageProfiles = buildProfiles()
+
+func buildProfiles() map[string]map[string]int {
+	profiles := make(map[string]map[string]int, len(languageSamples))
+	for language, sample := range languageSamples {
+		profiles[language] = rankTrigrams(sample)
+	}
+	return profiles
+}
+
+// rankTrigrams counts the letter trigrams of text, words padded with spaces,
+// and returns the rank of the profileSize most frequent ones
+func rankTrigrams(text string) map[string]int {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+	if len(trigrams) > profileSize {
+		trigrams = trigrams[:profileSize]
+	}
+
+	ranks := make(map[string]int, len(trigrams))
+	for rank, trigram := range trigrams {
+		ranks[trigram] = rank
+	}
+	return ranks
+}
+
+// DetectLanguage guesses the ISO 639-1 code of the language text is written
+// in, locally from its script or letter trigrams. hint is the language the
+// text is expected in, usually the sender's, and wins when it is about as
+// likely as the best guess. It returns "" when the text is too short or
+// ambiguous to tell.
+func DetectLanguage(text, hint string) string {
+	letters := 0
+	scripts := map[string]int{}
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.table, r) {
+				scripts[script.language]++
+				break
+			}
+		}
+	}
+
+	// Kana marks Japanese even when most of the letters are Han
+	if scripts["ja"] > 0 {
+		return "ja"
+	}
+	for language, count := range scripts {
+		if count*2 > letters {
+			return language
+		}
+	}
+
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	// Out-of-place distance between the text and each profile, the closest wins
+	ranks := rankTrigrams(text)
+	distances := make(map[string]int, len(languageProfiles))
+	best := ""
+	for language, profile := range languageProfiles {
+		distance := 0
+		for trigram, rank := range ranks {
+			if profileRank, ok := profile[trigram]; ok {
+				if profileRank > rank {
+					distance += profileRank - rank
+				} else {
+					distance += rank - profileRank
+				}
+			} else {
+				distance += profileSize
+			}
+		}
+		distances[language] = distance
+
+		if best == "" || distance < distances[best] || (distance == distances[best] && language < best) {
+			best = language
+		}
+	}
+
+	// Guesses closer than this to the best one are too close to call
+	margin := len(ranks) * profileSize / 50
+
+	if distance, ok := distances[hint]; ok && distance-distances[best] < margin {
+		return hint
+	}
+	for language, distance := range distances {
+		if language != best && distance-distances[best] < margin {
+			return ""
+		}
+	}
+	return best
+}
@@ -0,0 +1,49 @@
+package utils
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text, hint string
+		want       string
+	}{
+		{"Can you call me back when you get home tonight?", "", "en"},
+		{"See you tomorrow at the meeting with the team", "fr", "en"},
+		{"Nos vemos mañana en la reunión con el equipo", "", "es"},
+		{"Peux-tu me rappeler quand tu rentres ce soir ?", "", "fr"},
+		{"Kannst du mich heute Abend zurückrufen, wenn du nach Hause kommst?", "en", "de"},
+		{"Ci vediamo domani alla riunione con la squadra", "es", "it"},
+		{"Você pode me ligar quando chegar em casa hoje à noite?", "", "pt"},
+		{"Kun je me terugbellen als je vanavond thuis bent?", "", "nl"},
+
+		// Scripts tell the language on their own, whatever the length
+		{"Привет, как дела?", "en", "ru"},
+		{"こんにちは", "", "ja"},
+		{"東京に行きます", "", "ja"},
+		{"你好吗", "", "zh"},
+		{"안녕하세요", "", "ko"},
+		{"Καλημέρα", "", "el"},
+
+		// Too short to tell
+		{"ok", "en", ""},
+		{"hola", "", ""},
+		{"👍👍", "", ""},
+		{"", "", ""},
+	}
+
+	for _, test := range tests {
+		if got := DetectLanguage(test.text, test.hint); got != test.want {
+			t.Errorf("DetectLanguage(%q, %q) = %q, want %q", test.text, test.hint, got, test.want)
+		}
+	}
+}
+
+func TestDetectLanguageIsDeterministic(t *testing.T) {
+	text := "Obrigado, nos vemos amanhã na reunião com a equipe"
+	want := DetectLanguage(text, "")
+	for i := 0; i < 20; i++ {
+		if got := DetectLanguage(text, ""); got != want {
+			t.Fatalf("DetectLanguage returned %q then %q", want, got)
+		}
+	}
+}
@@ -1 +1,146 @@
 package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Translator turns text written in the source language into the target
+// language. Languages are ISO 639-1 codes like "en"; an empty source asks the
+// translator to detect it.
+type Translator interface {
+	Translate(ctx context.Context, text, source, target string) (string, error)
+}
+
+// LanguagePair is a translation direction
+type LanguagePair struct {
+	Source string
+	Target string
+}
+
+// DictionaryTranslator translates locally and deterministically by looking up
+// the whole text, then each word, in a per-pair dictionary. Words it does not
+// know are kept as they are, so without entries it returns the text unchanged.
+type DictionaryTranslator struct {
+	Entries map[LanguagePair]map[string]string
+}
+
+// NewDictionaryTranslator creates a dictionary translator from the given
+// entries, keyed by pair then by lower case source term
+func NewDictionaryTranslator(entries map[LanguagePair]map[string]string) *DictionaryTranslator {
+	if entries == nil {
+		entries = map[LanguagePair]map[string]string{}
+	}
+	return &DictionaryTranslator{Entries: entries}
+}
+
+func (t *DictionaryTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	dictionary := t.Entries[LanguagePair{Source: source, Target: target}]
+	if len(dictionary) == 0 || source == target {
+		return text, nil
+	}
+
+	if translated, ok := dictionary[strings.ToLower(strings.TrimSpace(text))]; ok {
+		return translated, nil
+	}
+
+	words := strings.Fields(text)
+	for i, word := range words {
+		// Keep punctuation around the word, "hello," becomes "hola,"
+		start := strings.IndexFunc(word, isWordRune)
+		end := strings.LastIndexFunc(word, isWordRune)
+		if start < 0 {
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(word[end:])
+		end += size
+		if translated, ok := dictionary[strings.ToLower(word[start:end])]; ok {
+			words[i] = word[:start] + translated + word[end:]
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+func isWordRune(r rune) bool {
+	return !strings.ContainsRune(".,;:!?¡¿\"'()[]", r)
+}
+
+// HTTPTranslator calls a LibreTranslate compatible API
+type HTTPTranslator struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+// NewHTTPTranslator creates a translator posting to the /translate endpoint
+// under baseURL
+func NewHTTPTranslator(baseURL, apiKey string, timeout time.Duration) *HTTPTranslator {
+	return &HTTPTranslator{
+		URL:    strings.TrimRight(baseURL, "/") + "/translate",
+		APIKey: apiKey,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+type translateRequest struct {
+	Q      string `json:"q"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Format string `json:"format"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+type translateResponse struct {
+	TranslatedText string `json:"translatedText"`
+	Error          string `json:"error"`
+}
+
+func (t *HTTPTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	if source == "" {
+		source = "auto"
+	}
+
+	body, err := json.Marshal(translateRequest{Q: text, Source: source, Target: target, Format: "text", APIKey: t.APIKey})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result translateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("translation provider answered %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translation provider answered %s: %s", resp.Status, result.Error)
+	}
+	return result.TranslatedText, nil
+}
+
+// TranslatorFromEnv picks the translator named by TRANSLATOR. "http" calls
+// the API at TRANSLATOR_URL with the optional TRANSLATOR_API_KEY, anything
+// else uses an empty dictionary and leaves messages untranslated.
+func TranslatorFromEnv() Translator {
+	if os.Getenv("TRANSLATOR") == "http" && os.Getenv("TRANSLATOR_URL") != "" {
+		return NewHTTPTranslator(os.Getenv("TRANSLATOR_URL"), os.Getenv("TRANSLATOR_API_KEY"),
+			EnvDuration("TRANSLATOR_TIMEOUT", 5*time.Second))
+	}
+	return NewDictionaryTranslator(nil)
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TranslationStore is the persistent tier of a TranslationCache, shared by
+// every replica
+type TranslationStore interface {
+	GetTranslation(key string) (string, bool, error)
+	SaveTranslation(key, text, source, target, translated string) error
+	PurgeTranslations(source, target string) (int64, error)
+}
+
+// TranslationCache sits in front of a Translator and remembers its answers,
+// first in a bounded in-memory LRU, then in an optional TranslationStore
+type TranslationCache struct {
+	translator Translator
+	store      TranslationStore
+	capacity   int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	memoryHits atomic.Int64
+	storeHits  atomic.Int64
+	misses     atomic.Int64
+}
+
+type cacheEntry struct {
+	key        string
+	source     string
+	target     string
+	translated string
+}
+
+// TranslationCacheStats counts the lookups answered by each tier
+type TranslationCacheStats struct {
+	Entries    int   `json:"entries"`
+	Capacity   int   `json:"capacity"`
+	MemoryHits int64 `json:"memory_hits"`
+	StoreHits  int64 `json:"store_hits"`
+	Misses     int64 `json:"misses"`
+}
+
+// NewTranslationCache caches the translations of translator, keeping up to
+// capacity of them in memory. store may be nil to cache in memory only.
+func NewTranslationCache(translator Translator, store TranslationStore, capacity int) *TranslationCache {
+	return &TranslationCache{
+		translator: translator,
+		store:      store,
+		capacity:   capacity,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// TranslationCacheKey identifies a translation by its normalized text and
+// languages. Surrounding and repeated whitespace does not matter.
+func TranslationCacheKey(text, source, target string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + target + "\x00" + normalizeText(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func (c *TranslationCache) Translate(ctx context.Context, text, source, target string) (string, error) {
+	key := TranslationCacheKey(text, source, target)
+
+	if translated, ok := c.get(key); ok {
+		c.memoryHits.Add(1)
+		return translated, nil
+	}
+
+	if c.store != nil {
+		translated, ok, err := c.store.GetTranslation(key)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read translation cache")
+		} else if ok {
+			c.storeHits.Add(1)
+			c.put(cacheEntry{key: key, source: source, target: target, translated: translated})
+			return translated, nil
+		}
+	}
+
+	c.misses.Add(1)
+	translated, err := c.translator.Translate(ctx, text, source, target)
+	if err != nil {
+		return "", err
+	}
+	// A translator without the pair, like an empty DictionaryTranslator,
+	// hands the text back; remembering that would outlive a real provider
+	if normalizeText(translated) == normalizeText(text) {
+		return translated, nil
+	}
+
+	c.put(cacheEntry{key: key, source: source, target: target, translated: translated})
+	if c.store != nil {
+		if err := c.store.SaveTranslation(key, normalizeText(text), source, target, translated); err != nil {
+			log.Error().Err(err).Msg("Failed to write translation cache")
+		}
+	}
+	return translated, nil
+}
+
+func (c *TranslationCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(cacheEntry).translated, true
+}
+
+func (c *TranslationCache) put(entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).key)
+	}
+}
+
+// Stats returns the lookup counters since the cache was created
+func (c *TranslationCache) Stats() TranslationCacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return TranslationCacheStats{
+		Entries:    entries,
+		Capacity:   c.capacity,
+		MemoryHits: c.memoryHits.Load(),
+		StoreHits:  c.storeHits.Load(),
+		Misses:     c.misses.Load(),
+	}
+}
+
+// Purge drops the cached translations from source to target from both tiers,
+// any language matching when empty, and returns how many the store held.
+// Other replicas keep their in-memory entries until they are evicted.
+func (c *TranslationCache) Purge(source, target string) (int64, error) {
+	c.mu.Lock()
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(cacheEntry)
+		if (source == "" || entry.source == source) && (target == "" || entry.target == target) {
+			c.order.Remove(element)
+			delete(c.entries, entry.key)
+		}
+		element = next
+	}
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return 0, nil
+	}
+	return c.store.PurgeTranslations(source, target)
+}
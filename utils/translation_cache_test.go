@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingTranslator prefixes text with the target language and counts how
+// often it was called
+type countingTranslator struct {
+	calls int
+	fail  bool
+}
+
+func (t *countingTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	t.calls++
+	if t.fail {
+		return "", errors.New("provider down")
+	}
+	return target + ":" + text, nil
+}
+
+// memoryStore is a TranslationStore kept in a map
+type memoryStore struct {
+	entries map[string]storedEntry
+}
+
+type storedEntry struct {
+	source, target, translated string
+}
+
+func (s *memoryStore) GetTranslation(key string) (string, bool, error) {
+	entry, ok := s.entries[key]
+	return entry.translated, ok, nil
+}
+
+func (s *memoryStore) SaveTranslation(key, text, source, target, translated string) error {
+	s.entries[key] = storedEntry{source: source, target: target, translated: translated}
+	return nil
+}
+
+func (s *memoryStore) PurgeTranslations(source, target string) (int64, error) {
+	var purged int64
+	for key, entry := range s.entries {
+		if (source == "" || entry.source == source) && (target == "" || entry.target == target) {
+			delete(s.entries, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func translate(t *testing.T, cache *TranslationCache, text, source, target string) string {
+	t.Helper()
+	translated, err := cache.Translate(context.Background(), text, source, target)
+	if err != nil {
+		t.Fatalf("Translate(%q) failed: %v", text, err)
+	}
+	return translated
+}
+
+func TestTranslationCacheKeyNormalizesWhitespace(t *testing.T) {
+	if TranslationCacheKey(" hello   world\n", "en", "es") != TranslationCacheKey("hello world", "en", "es") {
+		t.Error("keys differ for texts differing only in whitespace")
+	}
+	if TranslationCacheKey("hello", "en", "es") == TranslationCacheKey("hello", "en", "fr") {
+		t.Error("keys equal for different targets")
+	}
+	if TranslationCacheKey("hello", "en", "es") == TranslationCacheKey("Hello", "en", "es") {
+		t.Error("keys equal for texts differing in case")
+	}
+}
+
+func TestTranslationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	translator := &countingTranslator{}
+	cache := NewTranslationCache(translator, nil, 2)
+
+	translate(t, cache, "one", "en", "es")
+	translate(t, cache, "two", "en", "es")
+	translate(t, cache, "one", "en", "es") // one is now the most recent
+	translate(t, cache, "three", "en", "es")
+	if translator.calls != 3 {
+		t.Fatalf("provider called %d times, want 3", translator.calls)
+	}
+
+	translate(t, cache, "one", "en", "es")
+	if translator.calls != 3 {
+		t.Errorf("one was evicted, want two evicted")
+	}
+	translate(t, cache, "two", "en", "es")
+	if translator.calls != 4 {
+		t.Errorf("two was still cached, want it evicted")
+	}
+
+	stats := cache.Stats()
+	want := TranslationCacheStats{Entries: 2, Capacity: 2, MemoryHits: 2, Misses: 4}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestTranslationCacheFallsBackToStore(t *testing.T) {
+	store := &memoryStore{entries: map[string]storedEntry{}}
+	translator := &countingTranslator{}
+
+	translate(t, NewTranslationCache(translator, store, 10), "hello", "en", "es")
+
+	// A fresh replica finds the translation in the shared store
+	cache := NewTranslationCache(translator, store, 10)
+	if got := translate(t, cache, "hello ", "en", "es"); got != "es:hello" {
+		t.Errorf("got %q, want es:hello", got)
+	}
+	translate(t, cache, "hello", "en", "es")
+
+	if translator.calls != 1 {
+		t.Errorf("provider called %d times, want 1", translator.calls)
+	}
+	if stats := cache.Stats(); stats.StoreHits != 1 || stats.MemoryHits != 1 || stats.Misses != 0 {
+		t.Errorf("stats = %+v, want one store hit then one memory hit", stats)
+	}
+}
+
+func TestTranslationCacheDoesNotCacheFailures(t *testing.T) {
+	translator := &countingTranslator{fail: true}
+	cache := NewTranslationCache(translator, nil, 10)
+
+	if _, err := cache.Translate(context.Background(), "hello", "en", "es"); err == nil {
+		t.Fatal("want the provider error")
+	}
+
+	translator.fail = false
+	if got := translate(t, cache, "hello", "en", "es"); got != "es:hello" {
+		t.Errorf("got %q, want es:hello", got)
+	}
+}
+
+func TestTranslationCacheDoesNotCachePassthrough(t *testing.T) {
+	store := &memoryStore{entries: map[string]storedEntry{}}
+	cache := NewTranslationCache(NewDictionaryTranslator(nil), store, 10)
+
+	if got := translate(t, cache, "hello", "en", "es"); got != "hello" {
+		t.Errorf("got %q, want hello", got)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || len(store.entries) != 0 {
+		t.Errorf("%d in memory and %d in store, want the untranslated text in neither", stats.Entries, len(store.entries))
+	}
+}
+
+func TestTranslationCachePurge(t *testing.T) {
+	store := &memoryStore{entries: map[string]storedEntry{}}
+	translator := &countingTranslator{}
+	cache := NewTranslationCache(translator, store, 10)
+
+	translate(t, cache, "hello", "en", "es")
+	translate(t, cache, "hello", "en", "fr")
+	translate(t, cache, "hola", "es", "en")
+
+	purged, err := cache.Purge("en", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 || cache.Stats().Entries != 2 {
+		t.Errorf("purged %d, %d left, want 1 purged and 2 left", purged, cache.Stats().Entries)
+	}
+
+	translate(t, cache, "hello", "en", "fr")
+	if translator.calls != 3 {
+		t.Errorf("en to fr was purged too")
+	}
+	translate(t, cache, "hello", "en", "es")
+	if translator.calls != 4 {
+		t.Errorf("en to es survived the purge")
+	}
+
+	if purged, _ := cache.Purge("", ""); purged != 3 || cache.Stats().Entries != 0 {
+		t.Errorf("purged %d, %d left, want everything purged", purged, cache.Stats().Entries)
+	}
+}
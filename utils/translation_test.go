@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDictionaryTranslator(t *testing.T) {
+	translator := NewDictionaryTranslator(map[LanguagePair]map[string]string{
+		{Source: "en", Target: "es"}: {
+			"hello":         "hola",
+			"friend":        "amigo",
+			"good morning":  "buenos días",
+			"see you later": "hasta luego",
+		},
+	})
+
+	tests := []struct {
+		text, source, target string
+		want                 string
+	}{
+		{"hello", "en", "es", "hola"},
+		{"Hello, friend!", "en", "es", "hola, amigo!"},
+		{"  Good morning ", "en", "es", "buenos días"},
+		{"see you later", "en", "es", "hasta luego"},
+		{"hello stranger", "en", "es", "hola stranger"},
+		{"hello", "en", "fr", "hello"},
+		{"hello", "en", "en", "hello"},
+		{"", "en", "es", ""},
+	}
+
+	for _, test := range tests {
+		got, err := translator.Translate(context.Background(), test.text, test.source, test.target)
+		if err != nil {
+			t.Fatalf("Translate(%q) failed: %v", test.text, err)
+		}
+		if got != test.want {
+			t.Errorf("Translate(%q, %s, %s) = %q, want %q", test.text, test.source, test.target, got, test.want)
+		}
+	}
+}
+
+func TestHTTPTranslator(t *testing.T) {
+	var received translateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/translate" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		if received.Target == "xx" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(translateResponse{Error: "xx is not supported"})
+			return
+		}
+		json.NewEncoder(w).Encode(translateResponse{TranslatedText: "hola"})
+	}))
+	defer server.Close()
+
+	translator := NewHTTPTranslator(server.URL+"/", "secret", time.Second)
+
+	got, err := translator.Translate(context.Background(), "hello", "", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hola" {
+		t.Errorf("got %q, want hola", got)
+	}
+	want := translateRequest{Q: "hello", Source: "auto", Target: "es", Format: "text", APIKey: "secret"}
+	if received != want {
+		t.Errorf("provider received %+v, want %+v", received, want)
+	}
+
+	if _, err := translator.Translate(context.Background(), "hello", "en", "xx"); err == nil {
+		t.Error("want an error when the provider rejects the request")
+	}
+}